@@ -1,28 +1,36 @@
 package main
 
 import (
+	"context"
 	. "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/cluster-autoscaling/cluster-controller"
 	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	"log"
 	"os"
-	"strconv"
 )
 func main() {
-	isInCluster,err:=strconv.ParseBool(os.Getenv("IS_IN_CLUSTER"))
+	// the configuration is read from the file in CONFIG_FILE, environment variables override its values,
+	// without CONFIG_FILE everything is read from the environment variables
+	config,err:=LoadSchedulerConfig(os.Getenv("CONFIG_FILE"))
 	if err!=nil{
-		panic("Missing environment variable 'IS_IN_CLUSTER'")
+		log.Fatal(err)
 	}
-	ignoreSchedule,err:=strconv.ParseBool(os.Getenv("IGNORE_SCHEDULE"))	// whether ignore auto-scaling schedule and force auto scaling to be on
-	if err!=nil{
-		panic("Missing environment variable 'IGNORE_SCHEDULE'")
+	// one Scheduler per worker pool, sharing the clients of the cluster
+	ibmCloudClient := NewIBMCloudClient(config.IBMCloud)
+	k8sClient := k8sutil.InitializeClient(config.InCluster)
+	schedulers := []*Scheduler{}
+	for _, poolConfig := range config.PoolConfigs() {
+		schedulers = append(schedulers, NewScheduler(ibmCloudClient,k8sClient,poolConfig))
+	}
+	// expose the scheduler metrics to Prometheus, ":9090" by default
+	k8sutil.ServeMetrics(config.MetricsAddress)
+	// stop auto scaling when kubernetes stops the pod
+	ctx := k8sutil.SetupSignalContext()
+	if config.LeaderElection.Enabled {
+		k8sutil.RunWithLeaderElection(ctx,k8sClient,config.LeaderElection.Namespace,config.LeaderElection.LockName,
+			func(ctx context.Context) {
+				AutoScalePools(ctx,schedulers,config.IgnoreSchedule)
+			})
+	} else {
+		AutoScalePools(ctx,schedulers,config.IgnoreSchedule)
 	}
-	workerPool := os.Getenv("WORKER_POOL_NAME")
-	nameSpace := os.Getenv("NAMESPACE")
-	maxNode, _ := strconv.Atoi(os.Getenv("MAX_NODE"))
-	minNode, _ := strconv.Atoi(os.Getenv("MIN_NODE"))
-	extraNode, _ := strconv.Atoi(os.Getenv("EXTRA_NODE"))
-	// now spark worker only
-	ibmCloudClient := NewIBMCloudClient()
-	k8sClient := k8sutil.InitializeClient(isInCluster)
-	sparkScheduler := NewScheduler(ibmCloudClient,k8sClient,workerPool,nameSpace,maxNode,minNode,extraNode)
-	sparkScheduler.AutoScale(ignoreSchedule)
-}
\ No newline at end of file
+}
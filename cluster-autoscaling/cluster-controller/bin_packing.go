@@ -0,0 +1,242 @@
+package cluster_controller
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"log"
+	"sort"
+)
+
+/*
+CPU and memory of a node or requested by a pod, in millicores and bytes
+ */
+type podResources struct {
+	milliCPU int64
+	memory   int64
+}
+
+func (resources podResources) fits(capacity podResources) bool {
+	return resources.milliCPU <= capacity.milliCPU && resources.memory <= capacity.memory
+}
+
+func (resources podResources) sub(used podResources) podResources {
+	return podResources{milliCPU: resources.milliCPU - used.milliCPU, memory: resources.memory - used.memory}
+}
+
+func resourcesOf(list apiv1.ResourceList) podResources {
+	resources := podResources{}
+	if cpu, ok := list[apiv1.ResourceCPU]; ok {
+		resources.milliCPU = cpu.MilliValue()
+	}
+	if memory, ok := list[apiv1.ResourceMemory]; ok {
+		resources.memory = memory.Value()
+	}
+	return resources
+}
+
+/*
+Return the resources requested by a pod: the sum of its containers, or the largest init container if it
+requests more, since the init containers run one after the other before the containers
+ */
+func podRequests(pod apiv1.Pod) podResources {
+	requests := podResources{}
+	for _, container := range pod.Spec.Containers {
+		containerRequests := resourcesOf(container.Resources.Requests)
+		requests.milliCPU += containerRequests.milliCPU
+		requests.memory += containerRequests.memory
+	}
+	for _, container := range pod.Spec.InitContainers {
+		initRequests := resourcesOf(container.Resources.Requests)
+		if initRequests.milliCPU > requests.milliCPU {
+			requests.milliCPU = initRequests.milliCPU
+		}
+		if initRequests.memory > requests.memory {
+			requests.memory = initRequests.memory
+		}
+	}
+	return requests
+}
+
+/*
+A node the pending pods can be packed onto, with its capacity left
+ */
+type packingNode struct {
+	nodeIP string //empty for the nodes without IP yet and the new nodes
+	free   podResources
+}
+
+/*
+Result of packing the pending pods onto the worker pool
+ */
+type packingResult struct {
+	newNodes    int             //nodes to add so all the pending pods fit
+	filledNodes map[string]bool //nodes receiving pending pods
+	skippedPods int             //pending pods which can't be helped by adding nodes
+}
+
+/*
+This function simulates the scheduling of the pending pods onto the worker pool with first-fit decreasing:
+the largest pods are placed first on the first node with enough capacity left, the existing nodes first,
+then new nodes with the capacity of template are added as needed.
+A pod which the scheduler hasn't tried to place yet, or which wouldn't fit even on an empty node of the worker pool
+(too large, or a node selector the worker pool doesn't match), is skipped, adding nodes wouldn't help it.
+
+Input
+-----
+pendingPods: pods of the workerPool which are not assigned to a node
+nodes: nodes of the workerPool with their capacity left
+template: allocatable capacity of a node of the workerPool
+templateLabels: labels of a node of the workerPool, to check the node selector of the pods
+
+Output
+------
+the number of new nodes needed and the existing nodes receiving pods
+ */
+func binPackPendingPods(pendingPods []apiv1.Pod, nodes []packingNode, template podResources,
+	templateLabels map[string]string) packingResult {
+	result := packingResult{filledNodes: map[string]bool{}}
+	pods := []apiv1.Pod{}
+	for _, pod := range pendingPods {
+		if !isUnschedulable(pod) {
+			log.Printf("Pending pod %s is not rejected by the scheduler yet, skip it\n", pod.Name)
+			result.skippedPods++
+			continue
+		}
+		if !podRequests(pod).fits(template) || !matchesNodeSelector(pod, templateLabels) {
+			log.Printf("Pending pod %s requesting %s doesn't fit on a node of the worker pool with %s, adding nodes won't help it\n",
+				pod.Name, formatResources(podRequests(pod)), formatResources(template))
+			result.skippedPods++
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		left, right := podRequests(pods[i]), podRequests(pods[j])
+		if left.milliCPU != right.milliCPU {
+			return left.milliCPU > right.milliCPU
+		}
+		return left.memory > right.memory
+	})
+	bins := append([]packingNode{}, nodes...)
+	for _, pod := range pods {
+		requests := podRequests(pod)
+		placed := false
+		for i := range bins {
+			if requests.fits(bins[i].free) {
+				bins[i].free = bins[i].free.sub(requests)
+				if bins[i].nodeIP != "" {
+					result.filledNodes[bins[i].nodeIP] = true
+				}
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			bins = append(bins, packingNode{free: template.sub(requests)})
+			result.newNodes++
+		}
+	}
+	return result
+}
+
+/*
+Return true if the scheduler tried to place the pod and found no node for it
+ */
+func isUnschedulable(pod apiv1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == apiv1.PodScheduled && condition.Status == apiv1.ConditionFalse &&
+			condition.Reason == apiv1.PodReasonUnschedulable {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesNodeSelector(pod apiv1.Pod, nodeLabels map[string]string) bool {
+	for key, value := range pod.Spec.NodeSelector {
+		if nodeLabels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+This function packs the pending pods onto the nodes of the workerPool, using the allocatable capacity of the
+kubernetes Node objects and the requests of all the pods running on them, in any namespace.
+The nodes being provisioned, without IP or with an IP but no Node object yet, are counted as empty nodes with the
+capacity of a node of the workerPool.
+
+Input
+-----
+nodesList: internal IPs of the nodes of the workerPool
+nodesByIP: Node objects of the workerPool by internal IP
+nodePods: pods of all namespaces running on the nodes of the workerPool, nil if they can't be read
+pendingPods: pods of the workerPool which are not assigned to a node
+
+Output
+------
+the result of the packing, and false if the capacity of the nodes is unknown, i.e. no Node object can be read
+ */
+func (schedulerClient *Scheduler) packPendingPods(nodesList []string, nodesByIP map[string]*apiv1.Node,
+	nodePods []apiv1.Pod, pendingPods []apiv1.Pod) (packingResult, bool) {
+	nodes := map[string]*apiv1.Node{}
+	var template *apiv1.Node
+	for _, nodeIP := range nodesList {
+		node, exist := nodesByIP[nodeIP]
+		if !exist {
+			continue
+		}
+		nodes[node.Name] = node
+		if template == nil {
+			template = node
+		}
+	}
+	if template == nil || nodePods == nil {
+		return packingResult{}, false
+	}
+	used := map[string]podResources{}
+	for _, pod := range nodePods {
+		if pod.Status.Phase == apiv1.PodSucceeded || pod.Status.Phase == apiv1.PodFailed {
+			continue
+		}
+		if _, exist := nodes[pod.Spec.NodeName]; exist {
+			requests := podRequests(pod)
+			used[pod.Spec.NodeName] = podResources{
+				milliCPU: used[pod.Spec.NodeName].milliCPU + requests.milliCPU,
+				memory:   used[pod.Spec.NodeName].memory + requests.memory,
+			}
+		}
+	}
+	templateCapacity := resourcesOf(template.Status.Allocatable)
+	packingNodes := []packingNode{}
+	for _, nodeIP := range nodesList {
+		node, exist := nodesByIP[nodeIP]
+		if !exist {
+			// the nodeIP is kept, so a node not registered yet is taken by the pods packed onto it
+			packingNodes = append(packingNodes, packingNode{nodeIP: nodeIP, free: templateCapacity})
+			continue
+		}
+		allocatable := resourcesOf(node.Status.Allocatable)
+		packingNodes = append(packingNodes, packingNode{nodeIP: nodeIP, free: allocatable.sub(used[node.Name])})
+	}
+	return binPackPendingPods(pendingPods, packingNodes, templateCapacity, template.Labels), true
+}
+
+/*
+Return the pods which are not assigned to a node
+ */
+func pendingPods(podlists []apiv1.Pod) []apiv1.Pod {
+	pending := []apiv1.Pod{}
+	for _, pod := range podlists {
+		if pod.Spec.NodeName == "" {
+			pending = append(pending, pod)
+		}
+	}
+	return pending
+}
+
+func formatResources(resources podResources) string {
+	return resource.NewMilliQuantity(resources.milliCPU, resource.DecimalSI).String() + " cpu, " +
+		resource.NewQuantity(resources.memory, resource.BinarySI).String() + " memory"
+}
@@ -0,0 +1,147 @@
+package cluster_controller
+
+import (
+	"context"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func TestPodRequests(t *testing.T) {
+	pod := newFakePodWithRequests("worker-1", "", "1500m", "2Gi")
+	pod.Spec.Containers = append(pod.Spec.Containers, apiv1.Container{Name: "sidecar",
+		Resources: apiv1.ResourceRequirements{Requests: apiv1.ResourceList{apiv1.ResourceCPU: resource.MustParse("500m")}}})
+	pod.Spec.InitContainers = []apiv1.Container{{Name: "init",
+		Resources: apiv1.ResourceRequirements{Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("4Gi")}}}}
+	assert.Equal(t, podRequests(pod), podResources{milliCPU: 2000, memory: 4 << 30})
+}
+
+func TestBinPackPendingPods(t *testing.T) {
+	template := podResources{milliCPU: 4000, memory: 16 << 30}
+	labels := map[string]string{"pool": "spark-worker"}
+	nodes := []packingNode{
+		{nodeIP: "10.0.0.1", free: podResources{milliCPU: 1000, memory: 4 << 30}},
+		{nodeIP: "10.0.0.2", free: template},
+	}
+	pods := []apiv1.Pod{
+		newFakePodWithRequests("worker-1", "", "3", "4Gi"),
+		newFakePodWithRequests("worker-2", "", "3", "4Gi"),
+		newFakePodWithRequests("worker-3", "", "1", "1Gi"),
+		newFakePodWithRequests("worker-4", "", "1", "2Gi"),
+	}
+	result := binPackPendingPods(pods, nodes, template, labels)
+	// the first 3 cores go to the empty node, the other 3 cores to a new node, 1 core to the busy node
+	// and the last core to what is left on the empty node
+	assert.Equal(t, result.newNodes, 1)
+	assert.DeepEqual(t, result.filledNodes, map[string]bool{"10.0.0.1": true, "10.0.0.2": true})
+	assert.Equal(t, result.skippedPods, 0)
+}
+
+func TestBinPackPendingPodsSkipsPodsNewNodesCantHelp(t *testing.T) {
+	template := podResources{milliCPU: 4000, memory: 16 << 30}
+	tooLarge := newFakePodWithRequests("worker-1", "", "8", "4Gi")
+	wrongSelector := newFakePodWithRequests("worker-2", "", "1", "1Gi")
+	wrongSelector.Spec.NodeSelector = map[string]string{"pool": "jhub"}
+	// not rejected by the scheduler yet, it may still be placed on an existing node
+	notTried := newFakePodWithRequests("worker-3", "", "1", "1Gi")
+	notTried.Status.Conditions = nil
+	result := binPackPendingPods([]apiv1.Pod{tooLarge, wrongSelector, notTried}, []packingNode{}, template,
+		map[string]string{"pool": "spark-worker"})
+	assert.Equal(t, result.newNodes, 0)
+	assert.Equal(t, result.skippedPods, 3)
+}
+
+func TestSimulationScaleOutByPendingPodRequests(t *testing.T) {
+	provider := newFakeNodePoolProvider(2)
+	pods := []apiv1.Pod{
+		newFakePodWithRequests("worker-1", "10.0.0.1", "3", "8Gi"),
+		newFakePodWithRequests("worker-2", "10.0.0.2", "1", "2Gi"),
+		// one pod of 3 cores fits on 10.0.0.2 and the pod of 1 core on 10.0.0.1, the others need 2 new nodes
+		newFakePodWithRequests("worker-3", "", "2", "4Gi"),
+		newFakePodWithRequests("worker-4", "", "1", "4Gi"),
+		newFakePodWithRequests("worker-5", "", "3", "4Gi"),
+		newFakePodWithRequests("worker-6", "", "3", "4Gi"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 10, 1, 1)
+	scheduler.maxScaleStep = 10
+	for _, nodeIP := range []string{"10.0.0.1", "10.0.0.2"} {
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNode(nodeIP, "4", "16Gi"))
+		assert.NilError(t, err)
+	}
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// 2 new nodes for the pending pods and 1 extra idle node
+	assert.DeepEqual(t, provider.resizeRequests, []int{5})
+	// the pods of all namespaces are listed once for the unused nodes and the packing
+	clusterLists := 0
+	for _, action := range scheduler.clientSet.(*fake.Clientset).Actions() {
+		if action.Matches("list", "pods") && action.GetNamespace() == "" {
+			clusterLists++
+		}
+	}
+	assert.Equal(t, clusterLists, 1)
+}
+
+func TestSimulationNoScaleOutForPodsNewNodesCantHelp(t *testing.T) {
+	provider := newFakeNodePoolProvider(2)
+	wrongSelector := newFakePodWithRequests("worker-3", "", "1", "1Gi")
+	wrongSelector.Spec.NodeSelector = map[string]string{"pool": "jhub"}
+	pods := []apiv1.Pod{
+		newFakePodWithRequests("worker-1", "10.0.0.1", "3", "8Gi"),
+		wrongSelector,
+		newFakePodWithRequests("worker-4", "", "64", "4Gi"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 10, 1, 1)
+	for _, nodeIP := range []string{"10.0.0.1", "10.0.0.2"} {
+		node := newFakeNode(nodeIP, "4", "16Gi")
+		node.Labels["pool"] = "spark-worker"
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(node)
+		assert.NilError(t, err)
+	}
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// one busy node and one idle node, the pending pods don't change it
+	assert.Assert(t, len(provider.resizeRequests) == 0)
+	assert.Assert(t, len(provider.removeRequests) == 0)
+}
+
+func TestSimulationPendingPodsPackOntoNodeRegistering(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	pods := []apiv1.Pod{
+		newFakePodWithRequests("worker-1", "10.0.0.1", "3", "8Gi"),
+		newFakePodWithRequests("worker-2", "", "3", "4Gi"),
+		newFakePodWithRequests("worker-3", "", "3", "4Gi"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 10, 1, 1)
+	scheduler.maxScaleStep = 10
+	// 10.0.0.3 has its IP but no Node object yet
+	for _, nodeIP := range []string{"10.0.0.1", "10.0.0.2"} {
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNode(nodeIP, "4", "16Gi"))
+		assert.NilError(t, err)
+	}
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// the pending pods fit on 10.0.0.2 and on the node registering, only the extra idle node is added
+	assert.DeepEqual(t, provider.resizeRequests, []int{4})
+}
+
+func TestSimulationScaleInKeepsNodeTakenByPendingPod(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	pods := []apiv1.Pod{
+		newFakePodWithRequests("worker-1", "10.0.0.1", "4", "8Gi"),
+		newFakePodWithRequests("worker-2", "", "1", "1Gi"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 5, 1, 0)
+	scheduler.scaleInPolicy = "oldest"
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), nil)
+	for nodeIP, age := range map[string]time.Duration{"10.0.0.1": 72 * time.Hour, "10.0.0.2": 48 * time.Hour, "10.0.0.3": time.Hour} {
+		node := newFakeNode(nodeIP, "4", "16Gi")
+		node.CreationTimestamp = metav1.NewTime(autoScalingOnTime.Add(-age))
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(node)
+		assert.NilError(t, err)
+	}
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// the pending pod is packed onto the oldest unused node 10.0.0.2, so the other unused node is removed
+	assert.DeepEqual(t, provider.removeRequests, []string{"10.0.0.3"})
+}
@@ -0,0 +1,275 @@
+package cluster_controller
+
+import (
+	"fmt"
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const calendarDateFormat = "2006-01-02"
+
+/*
+Auto scaling calender of a worker pool. Auto scaling is on while one of its windows is active, otherwise
+the workerPool is kept at its maximum size.
+The windows of an exception replace the weekly windows on the dates of the exception, e.g. holidays.
+ */
+type Calendar struct {
+	Windows    []CalendarWindow    `json:"windows"`
+	Exceptions []CalendarException `json:"exceptions,omitempty"`
+}
+
+/*
+A period of a day during which auto scaling is on.
+Start and End are "HH:MM" in the time zone of the worker pool, an End before Start wraps around the same day,
+i.e. "20:00" to "06:00" is on from midnight to 6am and from 8pm to midnight. Both empty means the whole day.
+Alternatively Schedule is a cron expression with a duration, e.g. "0 20 * * 1-5 for 10h", which can go on past midnight.
+The node numbers of the worker pool can be overridden while the window is active.
+ */
+type CalendarWindow struct {
+	Weekdays  []string `json:"weekdays,omitempty"` //e.g. ["Mon", "Tuesday"], every day when empty
+	Start     string   `json:"start,omitempty"`    //"00:00" when empty
+	End       string   `json:"end,omitempty"`      //"24:00" when empty
+	Schedule  string   `json:"schedule,omitempty"` //replaces Weekdays, Start and End when set
+	MaxNode   *int     `json:"maxNode,omitempty"`
+	MinNode   *int     `json:"minNode,omitempty"`
+	ExtraNode *int     `json:"extraNode,omitempty"`
+}
+
+/*
+Dates with their own windows instead of the weekly ones, no window means auto scaling is off the whole day
+ */
+type CalendarException struct {
+	Dates   []string         `json:"dates"` //"YYYY-MM-DD"
+	Windows []CalendarWindow `json:"windows,omitempty"`
+}
+
+/*
+The calender used when none is configured: weekdays from 8pm to 6am and the whole weekends
+ */
+func DefaultCalendar() Calendar {
+	return Calendar{
+		Windows: []CalendarWindow{
+			{Weekdays: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}, Start: "20:00", End: "06:00"},
+			{Weekdays: []string{"Sat", "Sun"}},
+		},
+	}
+}
+
+/*
+Load a calender from a YAML or JSON file
+ */
+func LoadCalendar(path string) (Calendar, error) {
+	calendar := Calendar{}
+	err := k8sutil.LoadConfig(path, &calendar)
+	return calendar, err
+}
+
+/*
+This function finds the window of the calender which is active at timeNow
+
+Input
+-----
+timeNow: current time in the time zone of the worker pool
+
+Output
+------
+the active window, and false if auto scaling is off at timeNow
+ */
+func (calendar Calendar) ActiveWindow(timeNow time.Time) (CalendarWindow, bool) {
+	date := timeNow.Format(calendarDateFormat)
+	for _, exception := range calendar.Exceptions {
+		for _, exceptionDate := range exception.Dates {
+			if exceptionDate == date {
+				return findActiveWindow(exception.Windows, timeNow)
+			}
+		}
+	}
+	return findActiveWindow(calendar.Windows, timeNow)
+}
+
+// the first matching window wins when windows overlap
+func findActiveWindow(windows []CalendarWindow, timeNow time.Time) (CalendarWindow, bool) {
+	for _, window := range windows {
+		if window.contains(timeNow) {
+			return window, true
+		}
+	}
+	return CalendarWindow{}, false
+}
+
+func (window CalendarWindow) contains(timeNow time.Time) bool {
+	if window.Schedule != "" {
+		cronWindow, err := k8sutil.ParseCronWindow(window.Schedule)
+		return err == nil && cronWindow.Active(timeNow)
+	}
+	if len(window.Weekdays) > 0 {
+		onWeekday := false
+		for _, weekday := range window.Weekdays {
+			parsed, _ := parseWeekday(weekday)
+			onWeekday = onWeekday || parsed == timeNow.Weekday()
+		}
+		if !onWeekday {
+			return false
+		}
+	}
+	start, end := window.minutes()
+	minute := timeNow.Hour()*60 + timeNow.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// midnight between start and end
+	return minute >= start || minute < end
+}
+
+/*
+Return the start and end of the window in minutes from midnight
+ */
+func (window CalendarWindow) minutes() (int, int) {
+	start, end := 0, 24*60
+	if window.Start != "" {
+		start, _ = parseClock(window.Start)
+	}
+	if window.End != "" {
+		end, _ = parseClock(window.End)
+	}
+	return start, end
+}
+
+/*
+Return the node numbers of the worker pool while the window is active
+ */
+func (window CalendarWindow) nodeBounds(minNode int, maxNode int, extraNode int) (int, int, int) {
+	if window.MinNode != nil {
+		minNode = *window.MinNode
+	}
+	if window.MaxNode != nil {
+		maxNode = *window.MaxNode
+	}
+	if window.ExtraNode != nil {
+		extraNode = *window.ExtraNode
+	}
+	return minNode, maxNode, extraNode
+}
+
+/*
+Add the problems of the calender to configErrors, the node number overrides are checked against the
+node numbers of poolConfig
+ */
+func (calendar Calendar) validate(configErrors *k8sutil.ConfigErrors, poolConfig PoolConfig) {
+	for i, window := range calendar.Windows {
+		window.validate(configErrors, fmt.Sprintf("calendar.windows[%d]", i), poolConfig, true)
+	}
+	for i, exception := range calendar.Exceptions {
+		name := fmt.Sprintf("calendar.exceptions[%d]", i)
+		if len(exception.Dates) == 0 {
+			configErrors.Add("%s of worker pool %q has no dates", name, poolConfig.WorkerPool)
+		}
+		for _, date := range exception.Dates {
+			if _, err := time.Parse(calendarDateFormat, date); err != nil {
+				configErrors.Add("%s of worker pool %q: invalid date %q, expected YYYY-MM-DD", name, poolConfig.WorkerPool, date)
+			}
+		}
+		for j, window := range exception.Windows {
+			window.validate(configErrors, fmt.Sprintf("%s.windows[%d]", name, j), poolConfig, false)
+		}
+	}
+}
+
+func (window CalendarWindow) validate(configErrors *k8sutil.ConfigErrors, name string, poolConfig PoolConfig, weekly bool) {
+	if !weekly && (len(window.Weekdays) > 0 || window.Schedule != "") {
+		configErrors.Add("%s of worker pool %q: weekdays and schedule can't be set in the windows of an exception",
+			name, poolConfig.WorkerPool)
+	}
+	if window.Schedule != "" {
+		if len(window.Weekdays) > 0 || window.Start != "" || window.End != "" {
+			configErrors.Add("%s of worker pool %q: schedule can't be set with weekdays, start or end", name, poolConfig.WorkerPool)
+		}
+		if _, err := k8sutil.ParseCronWindow(window.Schedule); err != nil {
+			configErrors.Add("%s of worker pool %q: %v", name, poolConfig.WorkerPool, err)
+		}
+	}
+	for _, weekday := range window.Weekdays {
+		if _, err := parseWeekday(weekday); err != nil {
+			configErrors.Add("%s of worker pool %q: %v", name, poolConfig.WorkerPool, err)
+		}
+	}
+	valid := true
+	for _, clock := range []string{window.Start, window.End} {
+		if _, err := parseClock(clock); clock != "" && err != nil {
+			configErrors.Add("%s of worker pool %q: %v", name, poolConfig.WorkerPool, err)
+			valid = false
+		}
+	}
+	if start, end := window.minutes(); valid && start == end {
+		configErrors.Add("%s of worker pool %q: start and end can't be the same, leave both empty for the whole day",
+			name, poolConfig.WorkerPool)
+	}
+	minNode, maxNode, extraNode := window.nodeBounds(poolConfig.MinNode, poolConfig.MaxNode, poolConfig.ExtraNode)
+	if minNode < 0 || extraNode < 0 || maxNode < 1 || minNode+extraNode > maxNode {
+		configErrors.Add("%s of worker pool %q: invalid node numbers min %d, max %d, extra %d",
+			name, poolConfig.WorkerPool, minNode, maxNode, extraNode)
+	}
+}
+
+/*
+Parse a "HH:MM" clock into minutes from midnight, "24:00" is the end of the day
+ */
+func parseClock(clock string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(clock, "%d:%d", &hour, &minute); err != nil || len(clock) != 5 ||
+		hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return hour*60 + minute, nil
+}
+
+/*
+Parse a weekday from its English name or its first three letters, case insensitive
+ */
+func parseWeekday(name string) (time.Weekday, error) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if strings.EqualFold(name, weekday.String()) || strings.EqualFold(name, weekday.String()[:3]) {
+			return weekday, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("invalid weekday %q", name)
+}
+
+/*
+This function reloads the calender of the scheduler when its file is modified, so the calender can be changed
+through a mounted ConfigMap without restarting the pod. An invalid file is logged and the current calender is kept.
+ */
+func (schedulerClient *Scheduler) reloadCalendar() {
+	if schedulerClient.calendarFile == "" {
+		return
+	}
+	fileInfo, err := os.Stat(schedulerClient.calendarFile)
+	if err != nil {
+		log.Println("Can't read the calender file, keep the current calender:", err)
+		return
+	}
+	if fileInfo.ModTime().Equal(schedulerClient.calendarModTime) {
+		return
+	}
+	schedulerClient.calendarModTime = fileInfo.ModTime()
+	calendar, err := LoadCalendar(schedulerClient.calendarFile)
+	configErrors := k8sutil.ConfigErrors{}
+	if err == nil {
+		calendar.validate(&configErrors, PoolConfig{
+			WorkerPool: schedulerClient.workerPool,
+			MaxNode:    schedulerClient.maxNode,
+			MinNode:    schedulerClient.minNode,
+			ExtraNode:  schedulerClient.extraNode,
+		})
+		err = configErrors.Err()
+	}
+	if err != nil {
+		log.Println("Invalid calender file, keep the current calender:", err)
+		return
+	}
+	log.Println("Calender is loaded from", schedulerClient.calendarFile)
+	schedulerClient.calendar = calendar
+}
@@ -0,0 +1,165 @@
+package cluster_controller
+
+import (
+	"context"
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	"gotest.tools/assert"
+	"io/ioutil"
+	apiv1 "k8s.io/api/core/v1"
+	"os"
+	"testing"
+	"time"
+)
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func TestCalendarActiveWindow(t *testing.T) {
+	calendar := Calendar{
+		Windows: []CalendarWindow{
+			{Weekdays: []string{"Monday", "Wednesday", "Friday"}, Start: "20:00", End: "06:00"},
+			{Weekdays: []string{"Tue"}, Start: "20:00", End: "22:00"},
+			{Weekdays: []string{"thu"}, Start: "10:00", End: "18:00"},
+			//now weekends auto-scaling all on since why not
+			{Weekdays: []string{"Sat", "Sun"}},
+		},
+	}
+	var on bool
+	// Test valid pass midnight time , should be true
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 17, 0, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	// Test valid turn on time at night , should be true
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 17, 20, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	// Test valid turn on time between start and end, should be true
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 18, 20, 1, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	// Test turn off time between end and start, should be false
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 19, 12, 0, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	// Test turn off time before start, should be false
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 20, 8, 0, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	// Test turn off time after end, should be false
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 20, 21, 0, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	// Test all true time , should be true
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 22, 0, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 23, 0, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+}
+
+func TestCalendarMinuteResolutionAndMultipleWindows(t *testing.T) {
+	calendar := Calendar{
+		Windows: []CalendarWindow{
+			{Start: "00:00", End: "07:30"},
+			{Start: "12:15", End: "13:45", MaxNode: intPtr(4)},
+		},
+	}
+	_, on := calendar.ActiveWindow(time.Date(2019, time.June, 19, 7, 29, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 19, 7, 30, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 19, 12, 14, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	window, on := calendar.ActiveWindow(time.Date(2019, time.June, 19, 12, 15, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	assert.Equal(t, *window.MaxNode, 4)
+}
+
+func TestCalendarCronSchedule(t *testing.T) {
+	calendar := Calendar{
+		Windows: []CalendarWindow{
+			{Schedule: "0 20 * * 1-5 for 10h", ExtraNode: intPtr(2)},
+			{Schedule: "@weekly for 48h"},
+		},
+	}
+	// Tuesday 5am is still in the window started on Monday night
+	window, on := calendar.ActiveWindow(time.Date(2019, time.June, 18, 5, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	assert.Equal(t, *window.ExtraNode, 2)
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 18, 12, 0, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	// @weekly starts on Sunday midnight
+	window, on = calendar.ActiveWindow(time.Date(2019, time.June, 24, 12, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	assert.Assert(t, window.ExtraNode == nil)
+
+	configErrors := k8sutil.ConfigErrors{}
+	Calendar{Windows: []CalendarWindow{{Schedule: "0 20 * * 1-5", Start: "20:00"}}}.validate(&configErrors, PoolConfig{MaxNode: 1})
+	assert.Equal(t, len(configErrors), 2, configErrors.Err())
+}
+
+func TestCalendarExceptions(t *testing.T) {
+	calendar := DefaultCalendar()
+	calendar.Exceptions = []CalendarException{
+		// a holiday on a Monday is on the whole day
+		{Dates: []string{"2019-07-01"}, Windows: []CalendarWindow{{}}},
+		// a Saturday with a deadline is off the whole day
+		{Dates: []string{"2019-06-22"}},
+	}
+	_, on := calendar.ActiveWindow(time.Date(2019, time.July, 1, 12, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+	_, on = calendar.ActiveWindow(time.Date(2019, time.July, 8, 12, 0, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 22, 12, 0, 0, 0, time.UTC))
+	assert.Assert(t, !on)
+	_, on = calendar.ActiveWindow(time.Date(2019, time.June, 23, 12, 0, 0, 0, time.UTC))
+	assert.Assert(t, on)
+}
+
+func TestCalendarValidation(t *testing.T) {
+	calendar := Calendar{
+		Windows: []CalendarWindow{
+			{Weekdays: []string{"Caturday"}, Start: "8:00", End: "25:00"},
+			{Start: "10:00", End: "10:00"},
+			{MinNode: intPtr(5), ExtraNode: intPtr(2)},
+		},
+		Exceptions: []CalendarException{
+			{Dates: []string{"2019-13-01"}, Windows: []CalendarWindow{{Weekdays: []string{"Mon"}}}},
+		},
+	}
+	poolConfig := PoolConfig{WorkerPool: "spark-worker", MaxNode: 6, MinNode: 1, ExtraNode: 1}
+	configErrors := k8sutil.ConfigErrors{}
+	calendar.validate(&configErrors, poolConfig)
+	// weekday, both times, same start and end, node numbers, date and weekdays in an exception
+	assert.Equal(t, len(configErrors), 7, configErrors.Err())
+}
+
+func TestSimulationWindowOverridesNodeNumbers(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+		newFakePod("worker-2", "10.0.0.2"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 5, 1, 1)
+	// 3 idle nodes are needed during the window instead of 1
+	calendar := Calendar{Windows: []CalendarWindow{{ExtraNode: intPtr(3)}}}
+	scheduler.autoScaleOnce(context.Background(), calendar, autoScalingOnTime, false)
+	assert.DeepEqual(t, provider.resizeRequests, []int{4})
+}
+
+func TestSchedulerReloadsCalendarFile(t *testing.T) {
+	path := writeConfigFile(t, "windows:\n- start: \"08:00\"\n  end: \"09:00\"\n")
+	defer os.Remove(path)
+	scheduler := newFakeScheduler(newFakeNodePoolProvider(1), []apiv1.Pod{}, 5, 1, 1)
+	scheduler.calendarFile = path
+	scheduler.reloadCalendar()
+	assert.DeepEqual(t, scheduler.calendar.Windows, []CalendarWindow{{Start: "08:00", End: "09:00"}})
+
+	// an invalid calender is ignored
+	assert.NilError(t, ioutil.WriteFile(path, []byte("windows:\n- start: \"8am\"\n"), 0600))
+	modTime := scheduler.calendarModTime.Add(time.Second)
+	assert.NilError(t, os.Chtimes(path, modTime, modTime))
+	scheduler.reloadCalendar()
+	assert.DeepEqual(t, scheduler.calendar.Windows, []CalendarWindow{{Start: "08:00", End: "09:00"}})
+
+	// the modified calender is loaded
+	assert.NilError(t, ioutil.WriteFile(path, []byte("windows:\n- weekdays: [Sat]\n"), 0600))
+	modTime = modTime.Add(time.Second)
+	assert.NilError(t, os.Chtimes(path, modTime, modTime))
+	scheduler.reloadCalendar()
+	assert.DeepEqual(t, scheduler.calendar.Windows, []CalendarWindow{{Weekdays: []string{"Sat"}}})
+}
@@ -0,0 +1,222 @@
+package cluster_controller
+
+import (
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	"strings"
+	"time"
+)
+
+/*
+Configuration of the auto scaling of one worker pool
+ */
+type PoolConfig struct {
+	WorkerPool    string            `json:"workerPool" env:"WORKER_POOL_NAME"`   //name of the workerPool
+	Namespace     string            `json:"namespace" env:"NAMESPACE"`           //namespace of the pods running in the workerPool
+	PodSelector   map[string]string `json:"podSelector,omitempty"`               //labels of the pods of the workerPool, "pool: <workerPool>" when empty
+	NodePoolLabel string            `json:"nodePoolLabel" env:"NODE_POOL_LABEL"` //label of the Node objects holding the name of their workerPool
+	MaxNode       int               `json:"maxNode" env:"MAX_NODE"`              //maximum nodes the workerPool is allowed to own
+	MinNode       int               `json:"minNode" env:"MIN_NODE"`              //minimum nodes the workerPool is allowed to own
+	ExtraNode     int               `json:"extraNode" env:"EXTRA_NODE"`          //extra idle nodes for additional usage
+	MaxScaleStep  int               `json:"maxScaleStep" env:"MAX_SCALE_STEP"`   //maximum nodes added or removed in one round
+	ScaleInPolicy string            `json:"scaleInPolicy" env:"SCALE_IN_POLICY"` //policy choosing the unused nodes to remove, e.g. "oldest"
+	DrainTimeout  string            `json:"drainTimeout" env:"DRAIN_TIMEOUT"`    //e.g. "5m", maximum time to evict the pods of a node before removing it
+	TimeZone      string            `json:"timeZone" env:"TIME_ZONE"`            //time zone of the auto scaling calender
+	Calendar      *Calendar         `json:"calendar,omitempty"`                  //auto scaling calender, the default calender when empty
+	CalendarFile  string            `json:"calendarFile" env:"CALENDAR_FILE"`    //file holding the calender, reloaded when it is modified
+	DryRun        *bool             `json:"dryRun,omitempty" env:"DRY_RUN"`      //only log and count the scale actions, false when unset
+}
+
+/*
+Configuration of the IBM Cloud API
+ */
+type IBMCloudConfig struct {
+	APIURL          string `json:"apiUrl" env:"IBM_CLOUD_API_URL"`
+	IAMURL          string `json:"iamUrl" env:"IBM_CLOUD_IAM_URL"`
+	APIKey          string `json:"apiKey" env:"IBM_CLOUD_API_KEY"`
+	IAMToken        string `json:"iamToken" env:"IBM_CLOUD_IAM_TOKEN"` //only used when no token can be requested with the API key
+	ClusterIDOrName string `json:"clusterIdOrName" env:"IBM_CLOUD_CLUSTER_ID_OR_NAME"`
+}
+
+/*
+Configuration of the cluster autoscaler, loaded from the file in CONFIG_FILE, any field can be overridden
+by the environment variable in its env tag. The embedded PoolConfig is the only worker pool, or the default values
+of the worker pools in Pools when several worker pools are auto scaled by the same process.
+The environment variables never reach the entries of Pools, they only override the embedded PoolConfig: with Pools,
+NAMESPACE, NODE_POOL_LABEL, MAX_SCALE_STEP, SCALE_IN_POLICY, DRAIN_TIMEOUT, TIME_ZONE, CALENDAR_FILE and DRY_RUN
+are the defaults of the worker pools which don't set them, and MAX_NODE, MIN_NODE and EXTRA_NODE have no effect.
+ */
+type SchedulerConfig struct {
+	PoolConfig
+	Pools          []PoolConfig                 `json:"pools,omitempty"` //worker pools auto scaled concurrently
+	InCluster      bool                         `json:"inCluster" env:"IS_IN_CLUSTER"`
+	IgnoreSchedule bool                         `json:"ignoreSchedule" env:"IGNORE_SCHEDULE"` //force auto scaling to be on
+	MetricsAddress string                       `json:"metricsAddress" env:"METRICS_ADDRESS"` //empty to disable the metrics endpoint
+	LeaderElection k8sutil.LeaderElectionConfig `json:"leaderElection"`
+	IBMCloud       IBMCloudConfig               `json:"ibmCloud"`
+}
+
+/*
+Return the configuration with its default values
+ */
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		PoolConfig: PoolConfig{
+			TimeZone:      "America/Edmonton",
+			MaxScaleStep:  1,
+			ScaleInPolicy: k8sutil.VictimPolicyRandom,
+			DrainTimeout:  "5m",
+			NodePoolLabel: "ibm-cloud.kubernetes.io/worker-pool-name",
+		},
+		InCluster:      true,
+		MetricsAddress: ":9090",
+	}
+}
+
+/*
+Load the configuration from the file at path and the environment variables, then validate it
+ */
+func LoadSchedulerConfig(path string) (SchedulerConfig, error) {
+	config := DefaultSchedulerConfig()
+	if err := k8sutil.LoadConfig(path, &config); err != nil {
+		return config, err
+	}
+	for i := range config.Pools {
+		config.Pools[i] = config.Pools[i].withDefaults(config.PoolConfig)
+	}
+	if config.LeaderElection.Namespace == "" {
+		config.LeaderElection.Namespace = config.PoolConfigs()[0].Namespace
+	}
+	if config.LeaderElection.LockName == "" {
+		// one lock per worker pool, so autoscalers of different worker pools don't block each other,
+		// a process auto scaling several worker pools holds a single lock for all of them
+		config.LeaderElection.LockName = "cluster-custom-autoscaler-" + config.WorkerPool
+		if len(config.Pools) > 0 {
+			config.LeaderElection.LockName = "cluster-custom-autoscaler"
+		}
+	}
+	return config, config.Validate()
+}
+
+/*
+Return the configurations of all the worker pools to auto scale, the embedded PoolConfig when Pools is empty
+ */
+func (config SchedulerConfig) PoolConfigs() []PoolConfig {
+	if len(config.Pools) == 0 {
+		return []PoolConfig{config.PoolConfig}
+	}
+	return config.Pools
+}
+
+/*
+Fill the fields the worker pool doesn't set from defaults, the node numbers and the pod selector are specific to
+every worker pool and never filled
+ */
+func (config PoolConfig) withDefaults(defaults PoolConfig) PoolConfig {
+	if config.Namespace == "" {
+		config.Namespace = defaults.Namespace
+	}
+	if config.NodePoolLabel == "" {
+		config.NodePoolLabel = defaults.NodePoolLabel
+	}
+	if config.MaxScaleStep == 0 {
+		config.MaxScaleStep = defaults.MaxScaleStep
+	}
+	if config.ScaleInPolicy == "" {
+		config.ScaleInPolicy = defaults.ScaleInPolicy
+	}
+	if config.DrainTimeout == "" {
+		config.DrainTimeout = defaults.DrainTimeout
+	}
+	if config.TimeZone == "" {
+		config.TimeZone = defaults.TimeZone
+	}
+	if config.Calendar == nil && config.CalendarFile == "" {
+		config.Calendar = defaults.Calendar
+		config.CalendarFile = defaults.CalendarFile
+	}
+	if config.DryRun == nil {
+		config.DryRun = defaults.DryRun
+	}
+	return config
+}
+
+/*
+Return an error listing all the invalid values of the configuration
+ */
+func (config SchedulerConfig) Validate() error {
+	configErrors := k8sutil.ConfigErrors{}
+	if len(config.Pools) > 0 && config.WorkerPool != "" {
+		configErrors.Add("workerPool (WORKER_POOL_NAME) and pools can't be both set, got %q", config.WorkerPool)
+	}
+	workerPools := map[string]bool{}
+	for _, poolConfig := range config.PoolConfigs() {
+		if workerPools[poolConfig.WorkerPool] {
+			configErrors.Add("worker pool %q is listed more than once in pools", poolConfig.WorkerPool)
+		}
+		workerPools[poolConfig.WorkerPool] = true
+		poolConfig.validate(&configErrors)
+	}
+	if config.IBMCloud.APIURL == "" {
+		configErrors.Add("ibmCloud.apiUrl (IBM_CLOUD_API_URL) is required")
+	}
+	if config.IBMCloud.ClusterIDOrName == "" {
+		configErrors.Add("ibmCloud.clusterIdOrName (IBM_CLOUD_CLUSTER_ID_OR_NAME) is required")
+	}
+	if config.IBMCloud.APIKey == "" && config.IBMCloud.IAMToken == "" {
+		configErrors.Add("either ibmCloud.apiKey (IBM_CLOUD_API_KEY) or ibmCloud.iamToken (IBM_CLOUD_IAM_TOKEN) is required")
+	}
+	if config.IBMCloud.APIKey != "" && config.IBMCloud.IAMURL == "" {
+		configErrors.Add("ibmCloud.iamUrl (IBM_CLOUD_IAM_URL) is required to request a token with the API key")
+	}
+	return configErrors.Err()
+}
+
+func (config PoolConfig) validate(configErrors *k8sutil.ConfigErrors) {
+	if config.WorkerPool == "" {
+		configErrors.Add("workerPool (WORKER_POOL_NAME) is required")
+	}
+	if config.Namespace == "" {
+		configErrors.Add("namespace (NAMESPACE) of worker pool %q is required", config.WorkerPool)
+	}
+	if config.MinNode < 0 || config.ExtraNode < 0 {
+		configErrors.Add("minNode (MIN_NODE) and extraNode (EXTRA_NODE) of worker pool %q can't be negative", config.WorkerPool)
+	}
+	if config.MaxNode < 1 {
+		configErrors.Add("maxNode (MAX_NODE) of worker pool %q must be at least 1", config.WorkerPool)
+	}
+	if config.MinNode+config.ExtraNode > config.MaxNode {
+		configErrors.Add("minNode + extraNode (%d + %d) of worker pool %q can't be larger than maxNode (%d)",
+			config.MinNode, config.ExtraNode, config.WorkerPool, config.MaxNode)
+	}
+	if config.MaxScaleStep < 1 {
+		configErrors.Add("maxScaleStep (MAX_SCALE_STEP) of worker pool %q must be at least 1", config.WorkerPool)
+	}
+	if !k8sutil.IsVictimPolicy(config.ScaleInPolicy) {
+		configErrors.Add("scaleInPolicy (SCALE_IN_POLICY) of worker pool %q must be one of %s, got %q",
+			config.WorkerPool, strings.Join(k8sutil.VictimPolicies, ", "), config.ScaleInPolicy)
+	}
+	if config.NodePoolLabel == "" {
+		configErrors.Add("nodePoolLabel (NODE_POOL_LABEL) of worker pool %q is required", config.WorkerPool)
+	}
+	if timeout, err := time.ParseDuration(config.DrainTimeout); err != nil || timeout < 0 {
+		configErrors.Add("drainTimeout (DRAIN_TIMEOUT) of worker pool %q must be a duration like \"5m\", got %q",
+			config.WorkerPool, config.DrainTimeout)
+	}
+	if _, err := time.LoadLocation(config.TimeZone); err != nil {
+		configErrors.Add("invalid timeZone (TIME_ZONE) %q of worker pool %q: %v", config.TimeZone, config.WorkerPool, err)
+	}
+	if config.Calendar != nil && config.CalendarFile != "" {
+		configErrors.Add("calendar and calendarFile (CALENDAR_FILE) of worker pool %q can't be both set", config.WorkerPool)
+	}
+	if config.Calendar != nil {
+		config.Calendar.validate(configErrors, config)
+	}
+	if config.CalendarFile != "" {
+		calendar, err := LoadCalendar(config.CalendarFile)
+		if err != nil {
+			configErrors.Add("%v", err)
+		} else {
+			calendar.validate(configErrors, config)
+		}
+	}
+}
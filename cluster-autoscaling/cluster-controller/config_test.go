@@ -0,0 +1,168 @@
+package cluster_controller
+
+import (
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+/*
+Write the content to a temporary config file, the caller removes it
+ */
+func writeConfigFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "scheduler-config-*.yaml")
+	assert.NilError(t, err)
+	defer file.Close()
+	_, err = file.WriteString(content)
+	assert.NilError(t, err)
+	return file.Name()
+}
+
+const validSchedulerConfig = `
+workerPool: spark-worker
+namespace: spark
+maxNode: 10
+minNode: 2
+extraNode: 1
+timeZone: UTC
+leaderElection:
+  enabled: true
+ibmCloud:
+  apiUrl: https://containers.cloud.ibm.com/global
+  iamUrl: https://iam.cloud.ibm.com/identity/token
+  apiKey: key
+  clusterIdOrName: my-cluster
+`
+
+func TestLoadSchedulerConfig(t *testing.T) {
+	path := writeConfigFile(t, validSchedulerConfig)
+	defer os.Remove(path)
+	config, err := LoadSchedulerConfig(path)
+	assert.NilError(t, err)
+	assert.Equal(t, config.WorkerPool, "spark-worker")
+	assert.Equal(t, config.MaxNode, 10)
+	assert.Equal(t, config.MinNode, 2)
+	assert.Equal(t, config.IBMCloud.ClusterIDOrName, "my-cluster")
+	// defaults are kept when the file doesn't set them
+	assert.Equal(t, config.InCluster, true)
+	assert.Equal(t, config.MetricsAddress, ":9090")
+	assert.Equal(t, config.MaxScaleStep, 1)
+	assert.Equal(t, config.DrainTimeout, "5m")
+	assert.Equal(t, config.NodePoolLabel, "ibm-cloud.kubernetes.io/worker-pool-name")
+	assert.Equal(t, config.ScaleInPolicy, "random")
+	assert.Equal(t, config.LeaderElection.Namespace, "spark")
+	assert.Equal(t, config.LeaderElection.LockName, "cluster-custom-autoscaler-spark-worker")
+}
+
+func TestLoadSchedulerConfigEnvOverrides(t *testing.T) {
+	os.Setenv("MAX_NODE", "20")
+	os.Setenv("IGNORE_SCHEDULE", "true")
+	defer os.Unsetenv("MAX_NODE")
+	defer os.Unsetenv("IGNORE_SCHEDULE")
+	path := writeConfigFile(t, validSchedulerConfig)
+	defer os.Remove(path)
+	config, err := LoadSchedulerConfig(path)
+	assert.NilError(t, err)
+	assert.Equal(t, config.MaxNode, 20)
+	assert.Equal(t, config.IgnoreSchedule, true)
+}
+
+func TestLoadSchedulerConfigInvalidEnv(t *testing.T) {
+	os.Setenv("MAX_NODE", "ten")
+	defer os.Unsetenv("MAX_NODE")
+	path := writeConfigFile(t, validSchedulerConfig)
+	defer os.Remove(path)
+	_, err := LoadSchedulerConfig(path)
+	assert.ErrorContains(t, err, "MAX_NODE")
+}
+
+func TestLoadSchedulerConfigUnknownField(t *testing.T) {
+	path := writeConfigFile(t, validSchedulerConfig+"maxNodes: 3\n")
+	defer os.Remove(path)
+	_, err := LoadSchedulerConfig(path)
+	assert.ErrorContains(t, err, "maxNodes")
+}
+
+func TestLoadSchedulerConfigValidation(t *testing.T) {
+	content := strings.Replace(validSchedulerConfig, "minNode: 2", "minNode: 10", 1)
+	content = strings.Replace(content, "timeZone: UTC", "timeZone: Mars/Olympus", 1)
+	content = strings.Replace(content, "namespace: spark", "", 1)
+	content += "maxScaleStep: 0\n"
+	content += "drainTimeout: 5 minutes\n"
+	content += "nodePoolLabel: \"\"\n"
+	content += "scaleInPolicy: lru\n"
+	path := writeConfigFile(t, content)
+	defer os.Remove(path)
+	_, err := LoadSchedulerConfig(path)
+	// all the problems are reported at once
+	assert.ErrorContains(t, err, "minNode + extraNode (10 + 1)")
+	assert.ErrorContains(t, err, "Mars/Olympus")
+	assert.ErrorContains(t, err, "namespace (NAMESPACE)")
+	assert.ErrorContains(t, err, "maxScaleStep (MAX_SCALE_STEP)")
+	assert.ErrorContains(t, err, "drainTimeout (DRAIN_TIMEOUT)")
+	assert.ErrorContains(t, err, "nodePoolLabel (NODE_POOL_LABEL)")
+	assert.ErrorContains(t, err, "scaleInPolicy (SCALE_IN_POLICY)")
+}
+
+const multiPoolSchedulerConfig = `
+namespace: spark
+timeZone: UTC
+scaleInPolicy: oldest
+leaderElection:
+  enabled: true
+pools:
+  - workerPool: spark-worker
+    maxNode: 10
+    extraNode: 1
+    dryRun: false
+  - workerPool: jhub
+    namespace: jhub
+    podSelector:
+      component: singleuser-server
+    maxNode: 5
+    scaleInPolicy: newest
+ibmCloud:
+  apiUrl: https://containers.cloud.ibm.com/global
+  apiKey: key
+  iamUrl: https://iam.cloud.ibm.com/identity/token
+  clusterIdOrName: my-cluster
+`
+
+func TestLoadSchedulerConfigPools(t *testing.T) {
+	os.Setenv("MAX_SCALE_STEP", "2")
+	defer os.Unsetenv("MAX_SCALE_STEP")
+	os.Setenv("DRY_RUN", "true")
+	defer os.Unsetenv("DRY_RUN")
+	path := writeConfigFile(t, multiPoolSchedulerConfig)
+	defer os.Remove(path)
+	config, err := LoadSchedulerConfig(path)
+	assert.NilError(t, err)
+	poolConfigs := config.PoolConfigs()
+	assert.Equal(t, len(poolConfigs), 2)
+	// the values the worker pools don't set come from the top level and the environment variables
+	assert.Equal(t, poolConfigs[0].Namespace, "spark")
+	assert.Equal(t, poolConfigs[0].ScaleInPolicy, "oldest")
+	assert.Equal(t, poolConfigs[0].MaxScaleStep, 2)
+	assert.Equal(t, poolConfigs[0].NodePoolLabel, "ibm-cloud.kubernetes.io/worker-pool-name")
+	assert.Equal(t, poolConfigs[1].Namespace, "jhub")
+	assert.Equal(t, poolConfigs[1].ScaleInPolicy, "newest")
+	assert.DeepEqual(t, poolConfigs[1].PodSelector, map[string]string{"component": "singleuser-server"})
+	// a worker pool setting dryRun to false keeps it, the others inherit DRY_RUN
+	assert.Equal(t, *poolConfigs[0].DryRun, false)
+	assert.Equal(t, *poolConfigs[1].DryRun, true)
+	assert.Equal(t, config.LeaderElection.Namespace, "spark")
+	assert.Equal(t, config.LeaderElection.LockName, "cluster-custom-autoscaler")
+}
+
+func TestLoadSchedulerConfigPoolsValidation(t *testing.T) {
+	content := "workerPool: default\n" + strings.Replace(multiPoolSchedulerConfig, "workerPool: jhub", "workerPool: spark-worker", 1)
+	content = strings.Replace(content, "maxNode: 5", "maxNode: 0", 1)
+	path := writeConfigFile(t, content)
+	defer os.Remove(path)
+	_, err := LoadSchedulerConfig(path)
+	assert.ErrorContains(t, err, "workerPool (WORKER_POOL_NAME) and pools can't be both set")
+	assert.ErrorContains(t, err, "worker pool \"spark-worker\" is listed more than once")
+	assert.ErrorContains(t, err, "maxNode (MAX_NODE) of worker pool \"spark-worker\" must be at least 1")
+}
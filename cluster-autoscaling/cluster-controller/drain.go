@@ -0,0 +1,206 @@
+package cluster_controller
+
+import (
+	"context"
+	"fmt"
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	apiv1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/util/retry"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Cordon the node, so no new pod is scheduled on it, then evict all its pods through the Eviction API and wait
+until they are gone, the same way as `kubectl drain --ignore-daemonsets`.
+An eviction refused because of a PodDisruptionBudget is retried every pollInterval until drainTimeout.
+The pods of DaemonSets and the mirror pods are not evicted, they go with the node. A pod without controller, e.g. a
+Spark worker or a notebook server, would be lost for good, so the node is kept like `kubectl drain` without --force.
+A node without Node object has no pod to evict.
+
+Input
+-----
+ctx: the drain is given up once ctx is cancelled
+nodeIP: internal IP of the node, its Node object is found by this address whatever its name
+
+Output
+------
+true if this function cordoned the node, false if it was already cordoned, e.g. by an admin, so it stays cordoned
+if the node is kept. nil once the node is drained, otherwise an error and the node is uncordoned if this function
+cordoned it
+ */
+func (schedulerClient *Scheduler) drainNode(ctx context.Context, nodeIP string) (bool, error) {
+	nodeName, err := schedulerClient.nodeName(nodeIP)
+	if apierrors.IsNotFound(err) {
+		log.Printf("Node %s has no Node object, nothing to drain\n", nodeIP)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("can't find the Node object of node %s: %v", nodeIP, err)
+	}
+	cordoned, err := schedulerClient.setUnschedulable(nodeName, true)
+	if err != nil {
+		return false, fmt.Errorf("can't cordon node %s: %v", nodeIP, err)
+	}
+	timeBegin := time.Now()
+	var drainErr error
+	for {
+		pods, err := schedulerClient.podsToEvict(nodeName)
+		if bare := barePods(pods); len(bare) > 0 {
+			drainErr = fmt.Errorf("node %s is kept, its pods without controller would be lost: %s", nodeIP,
+				strings.Join(bare, ", "))
+			break
+		}
+		if err == nil && len(pods) == 0 {
+			log.Printf("Node %s is drained in %v\n", nodeIP, time.Since(timeBegin))
+			return cordoned, nil
+		}
+		if err == nil {
+			err = schedulerClient.evictPods(pods)
+		}
+		if time.Since(timeBegin) > schedulerClient.drainTimeout {
+			drainErr = fmt.Errorf("node %s is not drained after %v, %d pods left: %v", nodeIP,
+				schedulerClient.drainTimeout, len(pods), err)
+			break
+		}
+		if !k8sutil.SleepWithContext(ctx, schedulerClient.pollInterval) {
+			drainErr = fmt.Errorf("the drain of node %s is given up on shutdown", nodeIP)
+			break
+		}
+	}
+	if cordoned {
+		schedulerClient.uncordonNode(nodeIP)
+	}
+	return false, drainErr
+}
+
+/*
+Set the unschedulable flag of the Node object named nodeName, return true if it is changed by this call.
+The update is retried on conflict, e.g. when the kubelet writes the status of the node at the same time.
+ */
+func (schedulerClient *Scheduler) setUnschedulable(nodeName string, unschedulable bool) (bool, error) {
+	nodesClient := schedulerClient.clientSet.CoreV1().Nodes()
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := nodesClient.Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Spec.Unschedulable == unschedulable {
+			changed = false
+			return nil
+		}
+		node.Spec.Unschedulable = unschedulable
+		_, err = nodesClient.Update(node)
+		changed = err == nil
+		return err
+	})
+	return changed, err
+}
+
+func (schedulerClient *Scheduler) uncordonNode(nodeIP string) {
+	nodeName, err := schedulerClient.nodeName(nodeIP)
+	if err == nil {
+		_, err = schedulerClient.setUnschedulable(nodeName, false)
+	}
+	if err != nil {
+		log.Printf("Can't uncordon node %s: %v\n", nodeIP, err)
+		return
+	}
+	log.Printf("Node %s is uncordoned\n", nodeIP)
+}
+
+/*
+Return the pods of all namespaces running on the Node object named nodeName which have to be evicted before the node
+is removed, a pod already being deleted is returned too so the drain waits for it
+ */
+func (schedulerClient *Scheduler) podsToEvict(nodeName string) ([]apiv1.Pod, error) {
+	pods, err := schedulerClient.clientSet.CoreV1().Pods("").List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	podsToEvict := []apiv1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != nodeName || !isWorkloadPod(pod) {
+			continue
+		}
+		podsToEvict = append(podsToEvict, pod)
+	}
+	return podsToEvict, nil
+}
+
+/*
+Return the namespace/name of the pods which no controller would recreate once evicted
+ */
+func barePods(pods []apiv1.Pod) []string {
+	bare := []string{}
+	for i := range pods {
+		if metav1.GetControllerOf(&pods[i]) == nil {
+			bare = append(bare, pods[i].Namespace+"/"+pods[i].Name)
+		}
+	}
+	return bare
+}
+
+/*
+Ask the Eviction API to evict the pods, the pods already being deleted are skipped.
+Return the last error other than a pod already gone, e.g. an eviction refused by a PodDisruptionBudget
+ */
+func (schedulerClient *Scheduler) evictPods(pods []apiv1.Pod) error {
+	var lastErr error
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		err := schedulerClient.clientSet.CoreV1().Pods(pod.Namespace).Evict(&policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Can't evict pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+/*
+Drain the nodes concurrently and return the ones which are drained, in the order of nodeIPs, and the drained nodes
+cordoned by this call, only those are uncordoned if they are kept
+ */
+func (schedulerClient *Scheduler) drainNodes(ctx context.Context, nodeIPs []string) ([]string, map[string]bool) {
+	var waitGroup sync.WaitGroup
+	isDrained := make([]bool, len(nodeIPs))
+	isCordoned := make([]bool, len(nodeIPs))
+	for i, nodeIP := range nodeIPs {
+		waitGroup.Add(1)
+		go func(i int, nodeIP string) {
+			defer waitGroup.Done()
+			cordoned, err := schedulerClient.drainNode(ctx, nodeIP)
+			if err != nil {
+				log.Println(err)
+				drainFailures.WithLabelValues(schedulerClient.workerPool).Inc()
+				return
+			}
+			isDrained[i], isCordoned[i] = true, cordoned
+		}(i, nodeIP)
+	}
+	waitGroup.Wait()
+	drained, cordoned := []string{}, map[string]bool{}
+	for i, nodeIP := range nodeIPs {
+		if isDrained[i] {
+			drained = append(drained, nodeIP)
+		}
+		if isCordoned[i] {
+			cordoned[nodeIP] = true
+		}
+	}
+	return drained, cordoned
+}
@@ -0,0 +1,159 @@
+package cluster_controller
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sort"
+	"testing"
+	"time"
+)
+
+/*
+Return the names of the pods of all namespaces running on the node
+*/
+func podNamesOnNode(t *testing.T, scheduler *Scheduler, nodeIP string) []string {
+	pods, err := scheduler.clientSet.CoreV1().Pods("").List(metav1.ListOptions{})
+	assert.NilError(t, err)
+	names := []string{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == nodeIP {
+			names = append(names, pod.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isUnschedulableNode(t *testing.T, scheduler *Scheduler, nodeIP string) bool {
+	node, err := scheduler.clientSet.CoreV1().Nodes().Get(nodeIP, metav1.GetOptions{})
+	assert.NilError(t, err)
+	return node.Spec.Unschedulable
+}
+
+func TestDrainNode(t *testing.T) {
+	otherNamespace := newFakePod("jupyter-1", "10.0.0.1")
+	otherNamespace.Namespace = "jhub"
+	daemonSet := newFakePod("fluentd-1", "10.0.0.1")
+	daemonSet.Namespace = "kube-system"
+	daemonSet.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "fluentd", Controller: &[]bool{true}[0]}}
+	mirror := newFakePod("kube-proxy-10.0.0.1", "10.0.0.1")
+	mirror.Namespace = "kube-system"
+	mirror.Annotations = map[string]string{apiv1.MirrorPodAnnotationKey: "mirror"}
+	completed := newFakePod("job-1", "10.0.0.1")
+	completed.Status.Phase = apiv1.PodSucceeded
+	pods := []apiv1.Pod{newFakePod("worker-1", "10.0.0.1"), otherNamespace, daemonSet, mirror, completed,
+		newFakePod("worker-2", "10.0.0.2")}
+	scheduler := newFakeScheduler(newFakeNodePoolProvider(2), pods, 5, 1, 1)
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), nil)
+	_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNode("10.0.0.1", "4", "16Gi"))
+	assert.NilError(t, err)
+
+	cordoned, err := scheduler.drainNode(context.Background(), "10.0.0.1")
+	assert.NilError(t, err)
+	assert.Assert(t, cordoned)
+	// the pods of all namespaces are evicted, except the DaemonSet, mirror and completed pods
+	assert.DeepEqual(t, podNamesOnNode(t, scheduler, "10.0.0.1"), []string{"fluentd-1", "job-1", "kube-proxy-10.0.0.1"})
+	assert.DeepEqual(t, podNamesOnNode(t, scheduler, "10.0.0.2"), []string{"worker-2"})
+	assert.Assert(t, isUnschedulableNode(t, scheduler, "10.0.0.1"))
+	// a node without Node object has nothing to drain
+	cordoned, err = scheduler.drainNode(context.Background(), "10.0.0.2")
+	assert.NilError(t, err)
+	assert.Assert(t, !cordoned)
+}
+
+func TestDrainNodeBlockedByPodDisruptionBudget(t *testing.T) {
+	scheduler := newFakeScheduler(newFakeNodePoolProvider(1), []apiv1.Pod{newFakePod("worker-1", "10.0.0.1")}, 5, 1, 1)
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), map[string]bool{"worker-1": true})
+	scheduler.drainTimeout = 10 * time.Millisecond
+	_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNode("10.0.0.1", "4", "16Gi"))
+	assert.NilError(t, err)
+
+	_, err = scheduler.drainNode(context.Background(), "10.0.0.1")
+	assert.ErrorContains(t, err, "node 10.0.0.1 is not drained after 10ms, 1 pods left")
+	assert.ErrorContains(t, err, "disruption budget")
+	// the node is uncordoned and keeps its pod
+	assert.DeepEqual(t, podNamesOnNode(t, scheduler, "10.0.0.1"), []string{"worker-1"})
+	assert.Assert(t, !isUnschedulableNode(t, scheduler, "10.0.0.1"))
+}
+
+func TestScaleInOnlyRemovesDrainedNodes(t *testing.T) {
+	provider := newFakeNodePoolProvider(5)
+	otherNamespace := newFakePod("jupyter-1", "10.0.0.2")
+	otherNamespace.Namespace = "jhub"
+	protected := newFakePod("jupyter-2", "10.0.0.3")
+	protected.Namespace = "jhub"
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{otherNamespace, protected}, 5, 1, 1)
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), map[string]bool{"jupyter-2": true})
+	scheduler.drainTimeout = 10 * time.Millisecond
+	for _, nodeIP := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNode(nodeIP, "4", "16Gi"))
+		assert.NilError(t, err)
+	}
+	provider.failRemove["10.0.0.4"] = true
+	provider.failRemove["10.0.0.5"] = true
+	// an admin cordoned 10.0.0.5 before, it stays cordoned
+	adminCordoned := newFakeNode("10.0.0.5", "4", "16Gi")
+	adminCordoned.Spec.Unschedulable = true
+	_, err := scheduler.clientSet.CoreV1().Nodes().Create(adminCordoned)
+	assert.NilError(t, err)
+	failures := testutil.ToFloat64(drainFailures.WithLabelValues("spark-worker"))
+
+	scheduler.ScaleIn(context.Background(), "spark-worker", []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"})
+	// 10.0.0.3 can't be drained and the cloud rejects the removal of 10.0.0.4, both are uncordoned and kept
+	assert.DeepEqual(t, provider.removeRequests, []string{"10.0.0.2"})
+	assert.DeepEqual(t, podNamesOnNode(t, scheduler, "10.0.0.2"), []string{})
+	assert.Assert(t, !isUnschedulableNode(t, scheduler, "10.0.0.3"))
+	assert.Assert(t, !isUnschedulableNode(t, scheduler, "10.0.0.4"))
+	assert.Assert(t, isUnschedulableNode(t, scheduler, "10.0.0.5"))
+	assert.Equal(t, testutil.ToFloat64(drainFailures.WithLabelValues("spark-worker")), failures+1)
+}
+
+func TestScaleInKeepsDrainedNodesOnShutdown(t *testing.T) {
+	provider := newFakeNodePoolProvider(4)
+	scheduler := newFakeScheduler(provider, nil, 5, 1, 1)
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), nil)
+	for _, nodeIP := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNode(nodeIP, "4", "16Gi"))
+		assert.NilError(t, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the controller is stopped once the first node is sent for removal
+	provider.onRemove = func(string) { cancel() }
+
+	scheduler.ScaleIn(ctx, "spark-worker", []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"})
+	assert.DeepEqual(t, provider.removeRequests, []string{"10.0.0.2"})
+	assert.Assert(t, isUnschedulableNode(t, scheduler, "10.0.0.2"))
+	assert.Assert(t, !isUnschedulableNode(t, scheduler, "10.0.0.3"))
+	assert.Assert(t, !isUnschedulableNode(t, scheduler, "10.0.0.4"))
+}
+
+func TestCordonRetriesOnConflict(t *testing.T) {
+	scheduler := newFakeScheduler(newFakeNodePoolProvider(1), nil, 5, 1, 1)
+	clientSet := scheduler.clientSet.(*fake.Clientset)
+	_, err := clientSet.CoreV1().Nodes().Create(newFakeNode("10.0.0.1", "4", "16Gi"))
+	assert.NilError(t, err)
+	// the first update loses the race with another writer of the Node object
+	conflicts := 1
+	clientSet.PrependReactor("update", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "10.0.0.1", errors.New("changed"))
+	})
+
+	cordoned, err := scheduler.setUnschedulable("10.0.0.1", true)
+	assert.NilError(t, err)
+	assert.Assert(t, cordoned)
+	assert.Assert(t, isUnschedulableNode(t, scheduler, "10.0.0.1"))
+}
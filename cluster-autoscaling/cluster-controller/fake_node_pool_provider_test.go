@@ -0,0 +1,298 @@
+package cluster_controller
+
+import (
+	"fmt"
+	apiv1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sync"
+	"time"
+)
+
+/*
+fakeNodePoolProvider is an in-memory NodePoolProvider used to simulate a worker pool in tests.
+Time is counted in ListNodes calls instead of wall clock so the scenarios are deterministic:
+a new node reports an empty IP for provisionPolls calls before it gets its IP, and a removed
+node keeps being listed for deletePolls calls before it disappears. A pool with zones is resized by
+its size per zone like IBM Cloud does.
+*/
+type fakeNodePoolProvider struct {
+	mutex          sync.Mutex
+	nodes          []*fakeNode
+	nextIP         int
+	provisionPolls int             //number of ListNodes calls a new node stays without IP
+	deletePolls    int             //number of ListNodes calls a removed node is still listed
+	failRemove     map[string]bool //nodes whose deletion is rejected by the cloud
+	unhealthy      bool            //simulate a network problem
+	broken         bool            //ListNodes panics, to simulate a bug hit by a round of auto scaling
+	resizeRequests []int           //target sizes of all the accepted resize requests
+	removeRequests []string        //node IPs of all the accepted remove requests
+	zones          []string        //zones of the pool, none for a single zone pool
+	onRemove       func(string)    //called with the node IP of every accepted remove request
+}
+
+type fakeNode struct {
+	ip             string
+	zone           string
+	provisionPolls int
+	deletePolls    int
+	deleting       bool
+}
+
+/*
+Create a fake worker pool with numOfNodes ready nodes
+*/
+func newFakeNodePoolProvider(numOfNodes int) *fakeNodePoolProvider {
+	provider := &fakeNodePoolProvider{failRemove: map[string]bool{}}
+	for i := 0; i < numOfNodes; i++ {
+		provider.nodes = append(provider.nodes, &fakeNode{ip: provider.newIP()})
+	}
+	return provider
+}
+
+/*
+Create a fake worker pool spread over zones with nodesPerZone[i] ready nodes in zones[i]
+*/
+func newFakeZonedNodePoolProvider(zones []string, nodesPerZone []int) *fakeNodePoolProvider {
+	provider := &fakeNodePoolProvider{failRemove: map[string]bool{}, zones: zones}
+	for i, zone := range zones {
+		for j := 0; j < nodesPerZone[i]; j++ {
+			provider.nodes = append(provider.nodes, &fakeNode{ip: provider.newIP(), zone: zone})
+		}
+	}
+	return provider
+}
+
+func (provider *fakeNodePoolProvider) newIP() string {
+	provider.nextIP++
+	return fmt.Sprintf("10.0.0.%d", provider.nextIP)
+}
+
+func (provider *fakeNodePoolProvider) ListNodes(workerPoolName string) []string {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.broken {
+		panic("fake worker pool " + workerPoolName + " is broken")
+	}
+	nodesIP := []string{}
+	remaining := []*fakeNode{}
+	for _, node := range provider.nodes {
+		if node.deleting {
+			if node.deletePolls <= 0 {
+				continue
+			}
+			node.deletePolls--
+		}
+		remaining = append(remaining, node)
+		if node.provisionPolls > 0 {
+			node.provisionPolls--
+			nodesIP = append(nodesIP, "")
+			continue
+		}
+		nodesIP = append(nodesIP, node.ip)
+	}
+	provider.nodes = remaining
+	return nodesIP
+}
+
+func (provider *fakeNodePoolProvider) ResizePool(workerPoolName string, targetSize int) bool {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.unhealthy {
+		return false
+	}
+	provider.resizeRequests = append(provider.resizeRequests, targetSize)
+	if len(provider.zones) > 0 {
+		// every zone grows to the size per zone, none is shrunk
+		sizePerZone := (targetSize + len(provider.zones) - 1) / len(provider.zones)
+		for _, zone := range provider.zones {
+			for size := len(provider.zoneNodes()[zone]); size < sizePerZone; size++ {
+				provider.nodes = append(provider.nodes, &fakeNode{ip: provider.newIP(), zone: zone, provisionPolls: provider.provisionPolls})
+			}
+		}
+		return true
+	}
+	for len(provider.nodes) < targetSize {
+		provider.nodes = append(provider.nodes, &fakeNode{ip: provider.newIP(), provisionPolls: provider.provisionPolls})
+	}
+	if len(provider.nodes) > targetSize {
+		provider.nodes = provider.nodes[:targetSize]
+	}
+	return true
+}
+
+/*
+ListZoneNodes implements ZonedNodePoolProvider, a pool without zones has none
+*/
+func (provider *fakeNodePoolProvider) ListZoneNodes(workerPoolName string) map[string][]string {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return provider.zoneNodes()
+}
+
+func (provider *fakeNodePoolProvider) zoneNodes() map[string][]string {
+	if len(provider.zones) == 0 {
+		return nil
+	}
+	zoneNodes := map[string][]string{}
+	for _, zone := range provider.zones {
+		zoneNodes[zone] = []string{}
+	}
+	for _, node := range provider.nodes {
+		nodeIP := node.ip
+		if node.provisionPolls > 0 {
+			nodeIP = ""
+		}
+		zoneNodes[node.zone] = append(zoneNodes[node.zone], nodeIP)
+	}
+	return zoneNodes
+}
+
+func (provider *fakeNodePoolProvider) RemoveNode(workerPoolName string, nodeIP string) bool {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.unhealthy || provider.failRemove[nodeIP] {
+		return false
+	}
+	for _, node := range provider.nodes {
+		if node.ip == nodeIP && !node.deleting {
+			node.deleting = true
+			node.deletePolls = provider.deletePolls
+			provider.removeRequests = append(provider.removeRequests, nodeIP)
+			if provider.onRemove != nil {
+				provider.onRemove(nodeIP)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (provider *fakeNodePoolProvider) HealthCheck() bool {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return !provider.unhealthy
+}
+
+/*
+Return the nodes that are ready, i.e. provisioned and not being deleted
+*/
+func (provider *fakeNodePoolProvider) readyNodes() []string {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	nodesIP := []string{}
+	for _, node := range provider.nodes {
+		if !node.deleting && node.provisionPolls == 0 {
+			nodesIP = append(nodesIP, node.ip)
+		}
+	}
+	return nodesIP
+}
+
+/*
+Create a Scheduler backed by the fake provider and a fake Clientset holding the given pods,
+the waiting intervals are shrunk so the scale loops never sleep for long
+*/
+func newFakeScheduler(provider *fakeNodePoolProvider, pods []apiv1.Pod, maxNode int, minNode int, extraNode int) *Scheduler {
+	clientSet := fake.NewSimpleClientset()
+	for i := range pods {
+		_, _ = clientSet.CoreV1().Pods(pods[i].Namespace).Create(&pods[i])
+	}
+	scheduler := NewScheduler(provider, clientSet, PoolConfig{
+		WorkerPool: "spark-worker",
+		Namespace:  "spark",
+		MaxNode:    maxNode,
+		MinNode:    minNode,
+		ExtraNode:  extraNode,
+		TimeZone:   "UTC",
+	})
+	scheduler.pollInterval = time.Millisecond
+	scheduler.scaleTimeout = time.Second
+	return scheduler
+}
+
+/*
+Create a spark worker pod in the worker pool, an empty nodeIP makes a pending pod
+*/
+func newFakePod(name string, nodeIP string) apiv1.Pod {
+	return apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "spark",
+			Labels:          map[string]string{"pool": "spark-worker"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "worker", Controller: &[]bool{true}[0]}},
+		},
+		Spec: apiv1.PodSpec{NodeName: nodeIP},
+	}
+}
+
+/*
+Create a Ready Node object of the worker pool named after its internal IP, with the given allocatable cpu and memory
+*/
+func newFakeNode(nodeIP string, cpu string, memory string) *apiv1.Node {
+	return newFakeNamedNode(nodeIP, nodeIP, cpu, memory)
+}
+
+/*
+Create a Ready Node object of the worker pool with the given name, internal IP, allocatable cpu and memory
+*/
+func newFakeNamedNode(name string, nodeIP string, cpu string, memory string) *apiv1.Node {
+	return &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"ibm-cloud.kubernetes.io/worker-pool-name": "spark-worker"},
+		},
+		Status: apiv1.NodeStatus{
+			Allocatable: apiv1.ResourceList{
+				apiv1.ResourceCPU:    resource.MustParse(cpu),
+				apiv1.ResourceMemory: resource.MustParse(memory),
+			},
+			Addresses:  []apiv1.NodeAddress{{Type: apiv1.NodeInternalIP, Address: nodeIP}},
+			Conditions: []apiv1.NodeCondition{{Type: apiv1.NodeReady, Status: apiv1.ConditionTrue}},
+		},
+	}
+}
+
+/*
+Create a pod of the worker pool requesting cpu and memory, a pending pod is rejected by the scheduler
+*/
+func newFakePodWithRequests(name string, nodeIP string, cpu string, memory string) apiv1.Pod {
+	pod := newFakePod(name, nodeIP)
+	pod.Spec.Containers = []apiv1.Container{{
+		Name: name,
+		Resources: apiv1.ResourceRequirements{Requests: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse(cpu),
+			apiv1.ResourceMemory: resource.MustParse(memory),
+		}},
+	}}
+	if nodeIP == "" {
+		pod.Status.Conditions = []apiv1.PodCondition{{
+			Type:   apiv1.PodScheduled,
+			Status: apiv1.ConditionFalse,
+			Reason: apiv1.PodReasonUnschedulable,
+		}}
+	}
+	return pod
+}
+
+/*
+Make the evictions of the fake Clientset delete the pod right away, the fake Clientset doesn't support them.
+The pods named in blocked are protected by a PodDisruptionBudget, their eviction is refused
+*/
+func addEvictionReactor(clientSet *fake.Clientset, blocked map[string]bool) {
+	clientSet.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1beta1.Eviction)
+		if blocked[eviction.Name] {
+			return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+		}
+		return true, nil, clientSet.Tracker().Delete(apiv1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+	})
+}
@@ -9,8 +9,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,6 +22,7 @@ type IBMCloudClient struct{
 	apiKey string
 	iamUrl string
 	iamToken string
+	tokenMutex sync.RWMutex	//the token is shared by the Schedulers of all the worker pools
 	clusterIdOrName string
 	getClusterInfoURI string
 	getWorkerPoolsURI string
@@ -33,14 +34,14 @@ type IBMCloudClient struct{
 /**
 Constructor for IBMCloudClient struct for a cluster
  */
-func NewIBMCloudClient() *IBMCloudClient{
-	var apiUrl=os.Getenv("IBM_CLOUD_API_URL")
-	var iamUrl=os.Getenv("IBM_CLOUD_IAM_URL")
-	var apiKey=os.Getenv("IBM_CLOUD_API_KEY")
-	var clusterIdOrName=os.Getenv("IBM_CLOUD_CLUSTER_ID_OR_NAME")
+func NewIBMCloudClient(config IBMCloudConfig) *IBMCloudClient{
+	var apiUrl=config.APIURL
+	var iamUrl=config.IAMURL
+	var apiKey=config.APIKey
+	var clusterIdOrName=config.ClusterIDOrName
 	var iamToken=RequestAPIToken(apiKey,iamUrl)
 	if iamToken == "" {
-		iamToken=os.Getenv("IBM_CLOUD_IAM_TOKEN")
+		iamToken=config.IAMToken
 	}
 	getClusterInfoURI:="/v1/clusters/"+clusterIdOrName
 	getWorkerPoolsURI:="/v1/clusters/"+clusterIdOrName+"/workerpools"
@@ -66,7 +67,9 @@ func NewIBMCloudClient() *IBMCloudClient{
 
 func (ibmCloudClient *IBMCloudClient) getApiResponse(reqType string, apiUri string, additionalHeader map[string]string, reqBody io.Reader) []byte {
 	req, _:= http.NewRequest(reqType, ibmCloudClient.apiUrl+apiUri,reqBody)
+	ibmCloudClient.tokenMutex.RLock()
 	req.Header.Add("Authorization", ibmCloudClient.iamToken)
+	ibmCloudClient.tokenMutex.RUnlock()
 	req.Header.Add("accept", "application/json")
 	for k, v := range additionalHeader{
 		req.Header.Add(k,v)
@@ -172,14 +175,84 @@ func (ibmCloudClient *IBMCloudClient) getWorkersID(targetWorkerPoolName string,
 	return targetWorkerID
 }
 
+/**
+This function returns the zones of the target workerpool
+ */
+func (ibmCloudClient *IBMCloudClient) getWorkerPoolZones(workerPoolName string) []string {
+	workerPoolInfo:=ibmCloudClient.getApiResponse("GET", ibmCloudClient.resizeOrRebalanceWorkerPoolURI+workerPoolName, map[string]string{}, nil)
+	jsonIndex:=0
+	zone:=jsoniter.Get(workerPoolInfo,"zones",jsonIndex,"id").ToString()
+	zones:=[]string{}
+	for zone!=""{
+		zones=append(zones,zone)
+		jsonIndex+=1
+		zone=jsoniter.Get(workerPoolInfo,"zones",jsonIndex,"id").ToString()
+	}
+	return zones
+}
 
+/**
+This function returns the workers' node IPs of the target workerpool by zone, every zone of the workerpool is
+listed even when it has no worker
+ */
+func (ibmCloudClient *IBMCloudClient) getWorkersNodesIPByZone(targetWorkerPoolName string) map[string][]string {
+	zoneNodesIP:=make(map[string][]string)
+	for _, zone := range ibmCloudClient.getWorkerPoolZones(targetWorkerPoolName) {
+		zoneNodesIP[zone]=[]string{}
+	}
+	workersInfo:=string(ibmCloudClient.getApiResponse("GET", ibmCloudClient.getAllWorkersURI, map[string]string{}, nil))
+	workersInfoJson:="{\"workers\":"+workersInfo+"}"
+	jsonIndex:=0
+	workerInfoJson:=jsoniter.Get([]byte(workersInfoJson),"workers",jsonIndex).ToString()
+	for workerInfoJson!=""{
+		if jsoniter.Get([]byte(workerInfoJson),"poolName",).ToString()==targetWorkerPoolName{
+			zone:=jsoniter.Get([]byte(workerInfoJson),"location",).ToString()
+			workerNodeIP:=jsoniter.Get([]byte(workerInfoJson),"privateIP",).ToString()
+			zoneNodesIP[zone]=append(zoneNodesIP[zone],workerNodeIP)
+		}
+		jsonIndex+=1
+		workerInfoJson=jsoniter.Get([]byte(workersInfoJson),"workers",jsonIndex).ToString()
+	}
+	return zoneNodesIP
+}
 
 /**
-This function add one worker to the target workerpool
+This function returns the size per zone giving the workerpool at least the target size: IBM Cloud resizes every
+zone of the workerpool to the same size, so the target size is divided by the number of zones and rounded up. A
+zone is never shrunk by a resize, the workers are removed one by one by removeWorker.
+ */
+func sizePerZone(workerPoolTargetSize int, zoneNodesIP map[string][]string) int {
+	if len(zoneNodesIP) == 0 {
+		return workerPoolTargetSize
+	}
+	size:=(workerPoolTargetSize+len(zoneNodesIP)-1)/len(zoneNodesIP)
+	for _, nodesIP := range zoneNodesIP {
+		if len(nodesIP) > size {
+			size=len(nodesIP)
+		}
+	}
+	return size
+}
+
+
+
+/**
+This function add one worker to the target workerpool, a balanced multi-zone workerpool gets one worker in every zone
  */
 func (ibmCloudClient *IBMCloudClient) addOneWorker(workerPoolName string) bool {
 	workerPoolCurrentSize:=len(ibmCloudClient.getWorkersNodesIP(workerPoolName))
-	workerPoolTargetSize:=workerPoolCurrentSize+1
+	return ibmCloudClient.resizeWorkerPool(workerPoolName, workerPoolCurrentSize+1)
+}
+
+/**
+This function resize the target workerpool to at least the target size, the size per zone of a multi-zone
+workerpool is rounded up
+ */
+func (ibmCloudClient *IBMCloudClient) resizeWorkerPool(workerPoolName string, workerPoolTargetSize int) bool {
+	zoneNodesIP:=ibmCloudClient.getWorkersNodesIPByZone(workerPoolName)
+	workerPoolSizePerZone:=sizePerZone(workerPoolTargetSize, zoneNodesIP)
+	log.Printf("Resizing worker pool %s to %d nodes in each of its %d zones for a target size of %d\n",
+		workerPoolName, workerPoolSizePerZone, len(zoneNodesIP), workerPoolTargetSize)
 	clusterResourceGroup:= ibmCloudClient.getClusterResourceGroup()
 
 	additionalHeader:=make(map[string]string)
@@ -187,7 +260,7 @@ func (ibmCloudClient *IBMCloudClient) addOneWorker(workerPoolName string) bool {
 	reqData:=fmt.Sprintf(`{
 		"sizePerzone":%d,
 		"state":"resizing"
-	}`, workerPoolTargetSize)
+	}`, workerPoolSizePerZone)
 	reqBody := strings.NewReader(reqData)
 	reqResult:=string(ibmCloudClient.getApiResponse("PATCH", ibmCloudClient.resizeOrRebalanceWorkerPoolURI+workerPoolName, additionalHeader, reqBody))
 
@@ -203,7 +276,7 @@ TODO: this funciton is used to resize the worker pool after add/delete action, h
 TODO: So we might reuse it when cloud is stable
 This function re-balance the IBM cloud workerPool
 */
-func (ibmCloudClient IBMCloudClient) reSize(workerPoolName string) bool {
+func (ibmCloudClient *IBMCloudClient) reSize(workerPoolName string) bool {
 	workerPoolCurrentSize:=len(ibmCloudClient.getWorkersNodesIP(workerPoolName))
 	var workerPoolTargetSize int
 	workerPoolTargetSize =workerPoolCurrentSize-1 // need to validate
@@ -271,6 +344,42 @@ func (ibmCloudClient *IBMCloudClient) removeWorker(workerpoolName string, nodeIP
 	}
 }
 
+/**
+ListNodes implements NodePoolProvider, it returns the workers' node IPs of the workerpool
+ */
+func (ibmCloudClient *IBMCloudClient) ListNodes(workerPoolName string) []string {
+	return ibmCloudClient.getWorkersNodesIP(workerPoolName)
+}
+
+/**
+ResizePool implements NodePoolProvider, it resizes the workerpool to at least the target size
+ */
+func (ibmCloudClient *IBMCloudClient) ResizePool(workerPoolName string, targetSize int) bool {
+	return ibmCloudClient.resizeWorkerPool(workerPoolName, targetSize)
+}
+
+/**
+ListZoneNodes implements ZonedNodePoolProvider, it returns the workers' node IPs of the workerpool by zone
+ */
+func (ibmCloudClient *IBMCloudClient) ListZoneNodes(workerPoolName string) map[string][]string {
+	return ibmCloudClient.getWorkersNodesIPByZone(workerPoolName)
+}
+
+/**
+RemoveNode implements NodePoolProvider, it removes the worker with the node IP from the workerpool
+ */
+func (ibmCloudClient *IBMCloudClient) RemoveNode(workerPoolName string, nodeIP string) bool {
+	return ibmCloudClient.removeWorker(workerPoolName, nodeIP)
+}
+
+/**
+HealthCheck implements NodePoolProvider, the cluster information can only be retrieved
+when IBM Cloud API is reachable and the token is valid
+ */
+func (ibmCloudClient *IBMCloudClient) HealthCheck() bool {
+	return ibmCloudClient.getClusterResourceGroup() != ""
+}
+
 /*
 This function sends a POST request to IBM IAM service and retrieve the API access token given an API Key, the
 refresh the value in the ibmCloudClient
@@ -315,6 +424,8 @@ func RequestAPIToken(apiKey string, apiUrl string)string{
 refresh ibm iam token
 */
 func (ibmCloudClient *IBMCloudClient)RefreshToken() {
+	ibmCloudClient.tokenMutex.Lock()
+	defer ibmCloudClient.tokenMutex.Unlock()
 	ibmCloudClient.iamToken=""
 	iamToken := RequestAPIToken(ibmCloudClient.apiKey,
 		ibmCloudClient.iamUrl)
@@ -1,46 +1,58 @@
 package cluster_controller
 
 import (
+	"fmt"
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
 	"gotest.tools/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 func TestClusterResourceGroup(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	clusterResourceGroup:=cloudClient.getClusterResourceGroup()
 	assert.Assert(t,reflect.TypeOf(clusterResourceGroup).String()=="string")
 }
 
 func TestGetWorkerPools(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	workerPoolNames:=cloudClient.GetWorkerPools()
 	assert.Assert(t,len(workerPoolNames)>0)
 }
 
 
 func TestGetWorkerNodesIP(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	workerPoolNodesIP:=cloudClient.getWorkersNodesIP("default")
 	assert.Assert(t,len(workerPoolNodesIP)>0)
 }
 
 func TestGetWorkersID(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	workerPoolNodesIP:=cloudClient.getWorkersID("spark-worker", "10.166.255.119")
 	assert.Assert(t,len(workerPoolNodesIP)>0)
 }
 
 
 func TestRemoveWorker(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	successfulToRemoveWorker:=cloudClient.removeWorker("spark-worker", "10.166.255.73")
 	assert.Assert(t,successfulToRemoveWorker==true)
 }
 
 
 func TestAddOneWorker(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	successfulToAddWorker:=cloudClient.addOneWorker("spark-worker")
 	assert.Assert(t,successfulToAddWorker==true)
 }
@@ -49,16 +61,82 @@ func TestAddOneWorker(t *testing.T) {
 Be careful when running it since it will label the specified worker pool
  */
 func TestLabelWorkerPool(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	successfulToAddWorker:=cloudClient.labelWorkerPool("jhub-user", "pool", "jhub-user")
 	assert.Assert(t,successfulToAddWorker==true)
 }
 
 func TestRefreshToken(t *testing.T) {
-	var cloudClient= NewIBMCloudClient()
+	skipLiveTest(t)
+	var cloudClient= newLiveIBMCloudClient(t)
 	cloudClient.iamToken = "wrong_token"
 	prevToken := cloudClient.iamToken
 	cloudClient.RefreshToken()
 	assert.Assert(t,cloudClient.iamToken != "")
 	assert.Assert(t,cloudClient.iamToken != prevToken)
-}
\ No newline at end of file
+}
+
+/*
+The tests above talk to the real IBM Cloud API, run "go test -short" to skip them
+ */
+func skipLiveTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test against the live IBM Cloud API in short mode")
+	}
+}
+
+/*
+Create a client of the live IBM Cloud API configured by the IBM_CLOUD_* environment variables
+ */
+func newLiveIBMCloudClient(t *testing.T) *IBMCloudClient {
+	config := IBMCloudConfig{}
+	assert.NilError(t, k8sutil.ApplyEnvOverrides(&config))
+	return NewIBMCloudClient(config)
+}
+
+func TestSizePerZone(t *testing.T) {
+	zoneNodesIP := map[string][]string{"tor01": {"10.0.0.1", "10.0.0.2"}, "tor04": {"10.0.0.3"}, "tor05": {}}
+	// 7 nodes need 3 nodes in each of the 3 zones
+	assert.Equal(t, sizePerZone(7, zoneNodesIP), 3)
+	assert.Equal(t, sizePerZone(6, zoneNodesIP), 2)
+	// the largest zone is never shrunk
+	assert.Equal(t, sizePerZone(2, zoneNodesIP), 2)
+	// the size of a single zone workerpool is the target size
+	assert.Equal(t, sizePerZone(4, map[string][]string{"tor01": {"10.0.0.1"}}), 4)
+	assert.Equal(t, sizePerZone(4, map[string][]string{}), 4)
+}
+
+func TestResizeMultiZoneWorkerPool(t *testing.T) {
+	resizeRequests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PATCH" && r.URL.Path == "/v1/clusters/spark-cluster/workerpools/spark-worker":
+			body, _ := ioutil.ReadAll(r.Body)
+			resizeRequests = append(resizeRequests, strings.Join(strings.Fields(string(body)), ""))
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/v1/clusters/spark-cluster/workerpools/spark-worker":
+			fmt.Fprint(w, `{"name":"spark-worker","zones":[{"id":"tor01","workerCount":2},{"id":"tor04","workerCount":1},{"id":"tor05","workerCount":0}]}`)
+		case r.URL.Path == "/v1/clusters/spark-cluster/workers":
+			fmt.Fprint(w, `[{"id":"w1","poolName":"spark-worker","location":"tor01","privateIP":"10.0.0.1"},
+				{"id":"w2","poolName":"spark-worker","location":"tor01","privateIP":"10.0.0.2"},
+				{"id":"w3","poolName":"spark-worker","location":"tor04","privateIP":"10.0.0.3"},
+				{"id":"w4","poolName":"default","location":"tor05","privateIP":"10.0.0.4"}]`)
+		case r.URL.Path == "/v1/clusters/spark-cluster":
+			fmt.Fprint(w, `{"resourceGroup":"spark-group"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	cloudClient := NewIBMCloudClient(IBMCloudConfig{APIURL: server.URL, IAMURL: server.URL + "/identity/token",
+		ClusterIDOrName: "spark-cluster", IAMToken: "Bearer token"})
+
+	// the empty zone of the workerpool is listed
+	assert.DeepEqual(t, cloudClient.ListZoneNodes("spark-worker"), map[string][]string{
+		"tor01": {"10.0.0.1", "10.0.0.2"}, "tor04": {"10.0.0.3"}, "tor05": {}})
+	// 7 nodes in 3 zones take 3 nodes per zone, one more worker than the 3 nodes fills the smaller zones
+	assert.Assert(t, cloudClient.ResizePool("spark-worker", 7))
+	assert.Assert(t, cloudClient.addOneWorker("spark-worker"))
+	assert.DeepEqual(t, resizeRequests, []string{`{"sizePerzone":3,"state":"resizing"}`, `{"sizePerzone":2,"state":"resizing"}`})
+}
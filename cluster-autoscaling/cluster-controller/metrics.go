@@ -0,0 +1,99 @@
+package cluster_controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+Prometheus metrics of the node pool Scheduler, all of them are labelled with the name of the workerPool
+ */
+var (
+	nodesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cluster_autoscaler_nodes",
+		Help: "Number of nodes in the worker pool, including the ones being provisioned.",
+	}, []string{"pool"})
+	unusedNodesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cluster_autoscaler_unused_nodes",
+		Help: "Number of nodes in the worker pool without any pod of the worker pool.",
+	}, []string{"pool"})
+	pendingPodsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cluster_autoscaler_pending_pods",
+		Help: "Number of pods of the worker pool which are not assigned to a node.",
+	}, []string{"pool"})
+	targetNodesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cluster_autoscaler_target_nodes",
+		Help: "Size the worker pool is being scaled to in the last round, limited by the max scale step.",
+	}, []string{"pool"})
+	decisionGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cluster_autoscaler_decision",
+		Help: "Last auto scaling decision: 1 scale out, -1 scale in, 0 no action.",
+	}, []string{"pool"})
+	scheduleOnGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cluster_autoscaler_schedule_on",
+		Help: "1 if auto scaling is on according to the auto scaling calender, 0 otherwise.",
+	}, []string{"pool"})
+	scaleOutDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cluster_autoscaler_scale_out_duration_seconds",
+		Help:    "Time taken by ScaleOut until the new node is ready, the wait is given up on timeout or shutdown.",
+		Buckets: prometheus.ExponentialBuckets(15, 2, 8),
+	}, []string{"pool", "result"})
+	scaleInDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cluster_autoscaler_scale_in_duration_seconds",
+		Help:    "Time taken by ScaleIn until the node is removed, the wait is given up on timeout or shutdown.",
+		Buckets: prometheus.ExponentialBuckets(15, 2, 8),
+	}, []string{"pool", "result"})
+	drainFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cluster_autoscaler_drain_failures_total",
+		Help: "Nodes which couldn't be drained before being removed, they are uncordoned and kept.",
+	}, []string{"pool"})
+	dryRunGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cluster_autoscaler_dry_run",
+		Help: "1 if the Scheduler runs in dry-run mode, i.e. the scale actions are not sent to the cloud.",
+	}, []string{"pool"})
+	roundFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cluster_autoscaler_round_failures_total",
+		Help: "Auto scaling rounds of the worker pool which panicked, the round is skipped.",
+	}, []string{"pool"})
+	dryRunActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cluster_autoscaler_dry_run_actions_total",
+		Help: "Scale actions the Scheduler would have sent to the cloud in dry-run mode, an action intended by consecutive rounds is counted once.",
+	}, []string{"pool", "action"})
+)
+
+// values of the result label of the scale duration histograms
+const (
+	scaleResultConverged = "converged"
+	scaleResultTimeout   = "timeout"
+	scaleResultCancelled = "cancelled"
+)
+
+// values of the action label of the dry-run counter
+const (
+	dryRunActionScaleOut = "scale_out"
+	dryRunActionScaleIn  = "scale_in"
+)
+
+func init() {
+	prometheus.MustRegister(nodesGauge, unusedNodesGauge, pendingPodsGauge, targetNodesGauge, decisionGauge, scheduleOnGauge,
+		scaleOutDuration, scaleInDuration, drainFailures, dryRunGauge, roundFailures, dryRunActions)
+}
+
+/*
+Convert the decision of the scaling algorithm to the value of the decision gauge
+ */
+func decisionValue(scaleOut bool, scaleIn bool) float64 {
+	if scaleOut {
+		return 1
+	}
+	if scaleIn {
+		return -1
+	}
+	return 0
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
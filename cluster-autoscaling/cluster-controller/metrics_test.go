@@ -0,0 +1,36 @@
+package cluster_controller
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func TestSchedulerMetrics(t *testing.T) {
+	provider := newFakeNodePoolProvider(4)
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+		newFakePod("worker-2", ""),
+	}
+	for i := range pods {
+		pods[i].Labels["pool"] = "metrics-test"
+	}
+	scheduler := newFakeScheduler(provider, pods, 9, 1, 0)
+	scheduler.workerPool = "metrics-test"
+	scheduler.podSelector = map[string]string{"pool": "metrics-test"}
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.Equal(t, testutil.ToFloat64(scheduleOnGauge.WithLabelValues("metrics-test")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(nodesGauge.WithLabelValues("metrics-test")), 4.0)
+	assert.Equal(t, testutil.ToFloat64(unusedNodesGauge.WithLabelValues("metrics-test")), 3.0)
+	assert.Equal(t, testutil.ToFloat64(pendingPodsGauge.WithLabelValues("metrics-test")), 1.0)
+	// 2 unused nodes after the pending pod is assigned, while no extra node is needed
+	assert.Equal(t, testutil.ToFloat64(decisionGauge.WithLabelValues("metrics-test")), -1.0)
+
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOffTime, false)
+	assert.Equal(t, testutil.ToFloat64(scheduleOnGauge.WithLabelValues("metrics-test")), 0.0)
+	assert.Equal(t, testutil.ToFloat64(decisionGauge.WithLabelValues("metrics-test")), 1.0)
+	// the target is the size requested to the cloud, one more node than the 3 left since the max scale step is 1
+	assert.Equal(t, testutil.ToFloat64(targetNodesGauge.WithLabelValues("metrics-test")), 4.0)
+}
@@ -0,0 +1,31 @@
+package cluster_controller
+
+/*
+NodePoolProvider is the cloud side of the node pool autoscaler. The Scheduler only talks to the cloud
+through this interface, so any cloud that can list, resize and shrink a pool of worker nodes can be
+driven by the same auto scaling logic. IBMCloudClient is the IBM Cloud Kubernetes Service implementation.
+
+Nodes are identified by their internal IP address, which is also what the pods report in Spec.NodeName.
+A node that is still being provisioned is reported with an empty IP.
+*/
+type NodePoolProvider interface {
+	// ListNodes returns the internal IPs of all the nodes in the worker pool
+	ListNodes(workerPoolName string) []string
+	// ResizePool asks the cloud to resize the worker pool to targetSize nodes, returns true if the request is accepted
+	ResizePool(workerPoolName string, targetSize int) bool
+	// RemoveNode asks the cloud to delete one specific node of the worker pool, returns true if the request is accepted
+	RemoveNode(workerPoolName string, nodeIP string) bool
+	// HealthCheck returns false if the cloud API can't be reached
+	HealthCheck() bool
+}
+
+/*
+ZonedNodePoolProvider is implemented by the clouds spreading a pool over several zones and resizing it by its size
+per zone, ResizePool then rounds targetSize up to a multiple of the number of zones. The Scheduler uses the zones to
+scale out by whole nodes per zone and to keep the zones balanced when scaling in.
+*/
+type ZonedNodePoolProvider interface {
+	NodePoolProvider
+	// ListZoneNodes returns the internal IPs of the nodes in the worker pool by zone, every zone of the pool is listed
+	ListZoneNodes(workerPoolName string) map[string][]string
+}
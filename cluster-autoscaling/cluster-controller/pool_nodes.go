@@ -0,0 +1,340 @@
+package cluster_controller
+
+import (
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"log"
+	"time"
+)
+
+/*
+A node of the cloud without Node object is only unused once it has had none for this long: until then it may be
+registering with the cluster and about to get pods, afterwards it failed to register or left the cluster
+ */
+const nodeRegistrationGracePeriod = 10 * time.Minute
+
+/*
+Return the kubernetes Node objects of the workerPool by their internal IP, which is how the NodePoolProvider
+identifies its nodes, e.g. the IBM Cloud client maps the internal IP to the worker ID. The Node objects are found
+by the nodePoolLabel holding the name of the workerPool, so the names of the Node objects don't matter.
+ */
+func (schedulerClient *Scheduler) poolNodesByIP() (map[string]*apiv1.Node, error) {
+	nodes, err := schedulerClient.clientSet.CoreV1().Nodes().List(metav1.ListOptions{
+		LabelSelector: labels.Set{schedulerClient.nodePoolLabel: schedulerClient.workerPool}.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	nodesByIP := map[string]*apiv1.Node{}
+	for i := range nodes.Items {
+		if nodeIP := internalIP(&nodes.Items[i]); nodeIP != "" {
+			nodesByIP[nodeIP] = &nodes.Items[i]
+		}
+	}
+	return nodesByIP, nil
+}
+
+/*
+Return the name of the Node object of the node with the internal IP nodeIP, a NotFound error if it has none
+ */
+func (schedulerClient *Scheduler) nodeName(nodeIP string) (string, error) {
+	nodesByIP, err := schedulerClient.poolNodesByIP()
+	if err != nil {
+		return "", err
+	}
+	node, exist := nodesByIP[nodeIP]
+	if !exist {
+		return "", apierrors.NewNotFound(schema.GroupResource{Resource: "nodes"}, nodeIP)
+	}
+	return node.Name, nil
+}
+
+/*
+Return the pods of all namespaces running on the Node objects of the workerPool. The pods are listed once per round,
+then shared by the search of the unused nodes and the packing of the pending pods.
+
+Input
+-----
+nodesByIP: Node objects of the workerPool by internal IP
+
+Output
+------
+the pods, never nil unless there is an error
+ */
+func (schedulerClient *Scheduler) poolNodePods(nodesByIP map[string]*apiv1.Node) ([]apiv1.Pod, error) {
+	nodeNames := map[string]bool{}
+	for _, node := range nodesByIP {
+		nodeNames[node.Name] = true
+	}
+	pods, err := schedulerClient.clientSet.CoreV1().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodePods := []apiv1.Pod{}
+	for _, pod := range pods.Items {
+		if nodeNames[pod.Spec.NodeName] {
+			nodePods = append(nodePods, pod)
+		}
+	}
+	return nodePods, nil
+}
+
+/*
+Return the internal IP address of a node, empty if it doesn't report one
+ */
+func internalIP(node *apiv1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == apiv1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+/*
+Return true if the Ready condition of the node is true
+ */
+func isNodeReady(node *apiv1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == apiv1.NodeReady {
+			return condition.Status == apiv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+/*
+Return true if the pod keeps its node in use: a running or pending pod which isn't a mirror pod or a pod of
+a DaemonSet, those run on every node anyway
+ */
+func isWorkloadPod(pod apiv1.Pod) bool {
+	if pod.Status.Phase == apiv1.PodSucceeded || pod.Status.Phase == apiv1.PodFailed {
+		return false
+	}
+	if _, mirror := pod.Annotations[apiv1.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+	if controller := metav1.GetControllerOf(&pod); controller != nil && controller.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+/*
+Returns the unused nodes of a workerPool according to their kubernetes Node objects, the nodes being provisioned
+are skipped.
+A node is unused when no workload pod of any namespace runs on its Node object. A node which isn't Ready is never
+unused: it can't take pods, so it isn't counted as idle capacity, and it isn't removed while it may come back.
+A node without Node object isn't registered yet or left the cluster, it is only unused once it has had no Node
+object for nodeRegistrationGracePeriod, before that it is skipped like a node which isn't Ready.
+
+Input
+-----
+nodesList: internal IPs of the nodes of the workerPool given by the NodePoolProvider
+nodesByIP: Node objects of the workerPool by internal IP
+pods: pods of all namespaces running on the nodes
+unregisteredSince: since when every node without Node object has had none, by internal IP
+timeNow: current time
+
+Output
+------
+the internal IPs of the unused nodes, in the order of nodesList
+ */
+func findUnusedPoolNodes(nodesList []string, nodesByIP map[string]*apiv1.Node, pods []apiv1.Pod,
+	unregisteredSince map[string]time.Time, timeNow time.Time) []string {
+	usedNodes := map[string]bool{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" && isWorkloadPod(pod) {
+			usedNodes[pod.Spec.NodeName] = true
+		}
+	}
+	unusedNodes := []string{}
+	for _, nodeIP := range nodesList {
+		if nodeIP == "" {
+			continue
+		}
+		node, exist := nodesByIP[nodeIP]
+		if !exist {
+			since, tracked := unregisteredSince[nodeIP]
+			if !tracked || timeNow.Sub(since) < nodeRegistrationGracePeriod {
+				log.Printf("Node %s has no Node object yet, it is neither idle nor removed\n", nodeIP)
+				continue
+			}
+			log.Printf("Node %s has had no Node object for %v, it is unused\n", nodeIP, timeNow.Sub(since))
+			unusedNodes = append(unusedNodes, nodeIP)
+			continue
+		}
+		if !isNodeReady(node) {
+			log.Printf("Node %s (%s) is not Ready, it is neither idle nor removed\n", nodeIP, node.Name)
+			continue
+		}
+		if !usedNodes[node.Name] {
+			unusedNodes = append(unusedNodes, nodeIP)
+		}
+	}
+	return unusedNodes
+}
+
+/*
+Return the unused nodes of the workerPool from the kubernetes Node objects and the pods of all namespaces.
+When no Node object of the workerPool or no pod of its nodes can be read, the nodes are matched by internal IP
+with the node names of the pods of the workerPool instead, as FindUnusedNodes does.
+
+Input
+-----
+nodesList: internal IPs of the nodes of the workerPool
+nodesByIP: Node objects of the workerPool by internal IP, nil or empty if they can't be read
+nodePods: pods of all namespaces running on the nodes of the workerPool, nil if they can't be read
+podsList: pods of the workerPool
+timeNow: current time
+
+Output
+------
+the internal IPs of the unused nodes
+ */
+func (schedulerClient *Scheduler) unusedNodes(nodesList []string, nodesByIP map[string]*apiv1.Node,
+	nodePods []apiv1.Pod, podsList []apiv1.Pod, timeNow time.Time) []string {
+	if len(nodesByIP) == 0 {
+		log.Printf("No Node object labelled %s=%s, the unused nodes are matched by IP\n",
+			schedulerClient.nodePoolLabel, schedulerClient.workerPool)
+		return FindUnusedNodes(podsList, nodesList)
+	}
+	if nodePods == nil {
+		log.Println("No pod of the nodes can be read, the unused nodes are matched by IP")
+		return FindUnusedNodes(podsList, nodesList)
+	}
+	schedulerClient.trackUnregisteredNodes(nodesList, nodesByIP, timeNow)
+	return findUnusedPoolNodes(nodesList, nodesByIP, nodePods, schedulerClient.nodeUnregisteredSince, timeNow)
+}
+
+/*
+Record since when every node of the workerPool has had no Node object at timeNow, a node which got its Node object
+or left the cloud is forgotten
+ */
+func (schedulerClient *Scheduler) trackUnregisteredNodes(nodesList []string, nodesByIP map[string]*apiv1.Node,
+	timeNow time.Time) {
+	unregisteredSince := map[string]time.Time{}
+	for _, nodeIP := range nodesList {
+		if _, exist := nodesByIP[nodeIP]; exist || nodeIP == "" {
+			continue
+		}
+		if since, exist := schedulerClient.nodeUnregisteredSince[nodeIP]; exist {
+			unregisteredSince[nodeIP] = since
+		} else {
+			unregisteredSince[nodeIP] = timeNow
+		}
+	}
+	schedulerClient.nodeUnregisteredSince = unregisteredSince
+}
+
+/*
+Record since when every unused node has been idle at timeNow, a node which got pods or left is forgotten
+ */
+func (schedulerClient *Scheduler) trackIdleNodes(unusedNodes []string, timeNow time.Time) {
+	idleSince := map[string]time.Time{}
+	for _, nodeIP := range unusedNodes {
+		if since, exist := schedulerClient.nodeIdleSince[nodeIP]; exist {
+			idleSince[nodeIP] = since
+		} else {
+			idleSince[nodeIP] = timeNow
+		}
+	}
+	schedulerClient.nodeIdleSince = idleSince
+}
+
+/*
+Choose the unused nodes to remove with the scaleInPolicy of the workerPool. The age and the zone of a node come
+from its Node object. A node without Node object among the unused nodes failed to register within
+nodeRegistrationGracePeriod, findUnusedPoolNodes never returns the nodes still registering: it never finished its
+provisioning, so it is the newest and the provisioning-first policy removes it first. When the cloud
+spreads the workerPool over several zones, its zones are used instead and the zones are kept balanced whatever the
+policy, the policy only chooses among the nodes of the largest zones.
+
+Input
+-----
+unusedNodes: internal IPs of the unused nodes
+nodesByIP: Node objects of the workerPool by internal IP, to count the nodes in every zone
+zoneNodes: internal IPs of the nodes of the workerPool by zone from the cloud, nil for a single zone
+count: the number of nodes to remove
+timeNow: current time
+
+Output
+------
+the internal IPs of the nodes to remove
+ */
+func (schedulerClient *Scheduler) selectNodesToRemove(unusedNodes []string, nodesByIP map[string]*apiv1.Node,
+	zoneNodes map[string][]string, count int, timeNow time.Time) []string {
+	zoneSizes := map[string]int{}
+	for _, node := range nodesByIP {
+		zoneSizes[k8sutil.NodeZone(node)]++
+	}
+	nodeZones := map[string]string{}
+	if zoneNodes != nil {
+		zoneSizes = map[string]int{}
+		for zone, nodesIP := range zoneNodes {
+			zoneSizes[zone] = len(nodesIP)
+			for _, nodeIP := range nodesIP {
+				nodeZones[nodeIP] = zone
+			}
+		}
+	}
+	candidates := []k8sutil.VictimCandidate{}
+	for _, nodeIP := range unusedNodes {
+		candidate := k8sutil.VictimCandidate{Name: nodeIP, IdleSince: schedulerClient.nodeIdleSince[nodeIP]}
+		if node, exist := nodesByIP[nodeIP]; exist {
+			candidate.Created = node.CreationTimestamp.Time
+			candidate.Zone = k8sutil.NodeZone(node)
+		} else {
+			// a node which failed to register, the nodes still registering are never unused
+			candidate.Created = timeNow
+			candidate.Provisioning = true
+		}
+		if zone, exist := nodeZones[nodeIP]; exist {
+			candidate.Zone = zone
+		}
+		candidates = append(candidates, candidate)
+	}
+	if zoneNodes != nil {
+		return k8sutil.SelectZoneBalancedVictims(schedulerClient.scaleInPolicy, candidates, zoneSizes, count)
+	}
+	return k8sutil.SelectVictims(schedulerClient.scaleInPolicy, candidates, zoneSizes, count)
+}
+
+/*
+Return the internal IPs of the nodes of the workerPool by zone when the cloud spreads it over several zones,
+nil when the cloud doesn't tell the zones or the workerPool has a single zone
+ */
+func (schedulerClient *Scheduler) listZoneNodes() map[string][]string {
+	zonedClient, ok := schedulerClient.clusterClient.(ZonedNodePoolProvider)
+	if !ok {
+		return nil
+	}
+	zoneNodes := zonedClient.ListZoneNodes(schedulerClient.workerPool)
+	if len(zoneNodes) < 2 {
+		return nil
+	}
+	return zoneNodes
+}
+
+/*
+Round the target size of a scale out to whole nodes per zone: the cloud resizes a multi-zone workerPool by its size
+per zone, so the workerPool grows by steps of its number of zones. The target size is rounded up, or down when that
+passes maxNode, and stays currSize when not even one node per zone fits.
+ */
+func zoneAlignedTargetSize(targetSize int, currSize int, numOfZones int, maxNode int) int {
+	if numOfZones < 2 || targetSize <= currSize {
+		return targetSize
+	}
+	alignedSize := (targetSize + numOfZones - 1) / numOfZones * numOfZones
+	if alignedSize > maxNode {
+		alignedSize = maxNode / numOfZones * numOfZones
+	}
+	if alignedSize <= currSize {
+		return currSize
+	}
+	return alignedSize
+}
@@ -0,0 +1,208 @@
+package cluster_controller
+
+import (
+	"context"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func TestFindUnusedPoolNodes(t *testing.T) {
+	nodesByIP := map[string]*apiv1.Node{}
+	for i, nodeIP := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"} {
+		nodesByIP[nodeIP] = newFakeNamedNode("kube-worker-"+string('a'+rune(i)), nodeIP, "4", "16Gi")
+	}
+	nodesByIP["10.0.0.5"].Status.Conditions[0].Status = apiv1.ConditionFalse
+	otherNamespace := newFakePod("jupyter-1", "kube-worker-b")
+	otherNamespace.Namespace = "jhub"
+	daemonSet := newFakePod("fluentd-1", "kube-worker-c")
+	daemonSet.Namespace = "kube-system"
+	daemonSet.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "fluentd", Controller: &[]bool{true}[0]}}
+	completed := newFakePod("job-1", "kube-worker-d")
+	completed.Status.Phase = apiv1.PodSucceeded
+	pods := []apiv1.Pod{newFakePod("worker-1", "kube-worker-a"), otherNamespace, daemonSet, completed,
+		newFakePod("worker-pending", "")}
+
+	unregisteredSince := map[string]time.Time{
+		"10.0.0.6": autoScalingOnTime.Add(-time.Minute),
+		"10.0.0.7": autoScalingOnTime.Add(-nodeRegistrationGracePeriod),
+	}
+
+	unused := findUnusedPoolNodes([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6",
+		"10.0.0.7", "10.0.0.8", ""}, nodesByIP, pods, unregisteredSince, autoScalingOnTime)
+	// the pods are matched by the name of the Node objects, in any namespace, the DaemonSet and completed pods
+	// don't keep a node in use, the NotReady node is kept, and the nodes without Node object are only unused
+	// once they have had none for the grace period
+	assert.DeepEqual(t, unused, []string{"10.0.0.3", "10.0.0.4", "10.0.0.7"})
+}
+
+func TestPoolNodesByIP(t *testing.T) {
+	scheduler := newFakeScheduler(newFakeNodePoolProvider(2), []apiv1.Pod{}, 5, 1, 1)
+	otherPool := newFakeNamedNode("kube-worker-c", "10.0.0.3", "4", "16Gi")
+	otherPool.Labels["ibm-cloud.kubernetes.io/worker-pool-name"] = "default"
+	for _, node := range []*apiv1.Node{newFakeNamedNode("kube-worker-a", "10.0.0.1", "4", "16Gi"),
+		newFakeNamedNode("kube-worker-b", "10.0.0.2", "4", "16Gi"), otherPool} {
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(node)
+		assert.NilError(t, err)
+	}
+
+	nodesByIP, err := scheduler.poolNodesByIP()
+	assert.NilError(t, err)
+	assert.Equal(t, len(nodesByIP), 2)
+	assert.Equal(t, nodesByIP["10.0.0.2"].Name, "kube-worker-b")
+	nodeName, err := scheduler.nodeName("10.0.0.1")
+	assert.NilError(t, err)
+	assert.Equal(t, nodeName, "kube-worker-a")
+	_, err = scheduler.nodeName("10.0.0.3")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestSimulationScaleInNodesNotNamedByIP(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	otherNamespace := newFakePod("jupyter-1", "kube-worker-b")
+	otherNamespace.Namespace = "jhub"
+	pods := []apiv1.Pod{newFakePod("worker-1", "kube-worker-a"), otherNamespace}
+	scheduler := newFakeScheduler(provider, pods, 5, 1, 0)
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), nil)
+	for i, nodeIP := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNamedNode("kube-worker-"+string('a'+rune(i)), nodeIP, "4", "16Gi"))
+		assert.NilError(t, err)
+	}
+
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// only the node without pod in any namespace is removed, after being cordoned through its Node object
+	assert.DeepEqual(t, provider.removeRequests, []string{"10.0.0.3"})
+	node, err := scheduler.clientSet.CoreV1().Nodes().Get("kube-worker-c", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Assert(t, node.Spec.Unschedulable)
+}
+
+func TestSimulationKeepsNodeRegistering(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{newFakePod("worker-1", "10.0.0.1")}, 5, 1, 1)
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), nil)
+	for _, nodeIP := range []string{"10.0.0.1", "10.0.0.2"} {
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(newFakeNode(nodeIP, "4", "16Gi"))
+		assert.NilError(t, err)
+	}
+
+	// 10.0.0.3 has no Node object yet, only 10.0.0.2 is idle as the extra node
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.DeepEqual(t, provider.removeRequests, []string(nil))
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(),
+		autoScalingOnTime.Add(nodeRegistrationGracePeriod-time.Second), false)
+	assert.DeepEqual(t, provider.removeRequests, []string(nil))
+	// it failed to register, it is unused and removed first by provisioning-first, or newest as it has no age
+	scheduler.scaleInPolicy = "provisioning-first"
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(),
+		autoScalingOnTime.Add(nodeRegistrationGracePeriod), false)
+	assert.DeepEqual(t, provider.removeRequests, []string{"10.0.0.3"})
+}
+
+func TestSimulationScaleInOldestNode(t *testing.T) {
+	provider := newFakeNodePoolProvider(4)
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{newFakePod("worker-1", "10.0.0.1")}, 5, 1, 1)
+	scheduler.scaleInPolicy = "oldest"
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), nil)
+	for nodeIP, age := range map[string]time.Duration{"10.0.0.2": time.Hour, "10.0.0.3": 48 * time.Hour, "10.0.0.4": 24 * time.Hour} {
+		node := newFakeNode(nodeIP, "4", "16Gi")
+		node.CreationTimestamp = metav1.NewTime(autoScalingOnTime.Add(-age))
+		_, err := scheduler.clientSet.CoreV1().Nodes().Create(node)
+		assert.NilError(t, err)
+	}
+
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// 3 unused nodes while 1 extra node is needed, the oldest one is removed first
+	assert.DeepEqual(t, provider.removeRequests, []string{"10.0.0.3"})
+}
+
+func TestSelectNodesToRemove(t *testing.T) {
+	scheduler := newFakeScheduler(newFakeNodePoolProvider(4), []apiv1.Pod{}, 5, 1, 1)
+	nodesByIP := map[string]*apiv1.Node{}
+	for nodeIP, zone := range map[string]string{"10.0.0.1": "tor01", "10.0.0.2": "tor01", "10.0.0.3": "tor02"} {
+		nodesByIP[nodeIP] = newFakeNode(nodeIP, "4", "16Gi")
+		nodesByIP[nodeIP].Labels[apiv1.LabelZoneFailureDomain] = zone
+	}
+	unusedNodes := []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}
+
+	// the node without Node object failed to register
+	scheduler.scaleInPolicy = "provisioning-first"
+	assert.DeepEqual(t, scheduler.selectNodesToRemove(unusedNodes, nodesByIP, nil, 1, autoScalingOnTime), []string{"10.0.0.4"})
+	// tor01 has the most nodes
+	scheduler.scaleInPolicy = "zone-balanced"
+	assert.DeepEqual(t, scheduler.selectNodesToRemove(unusedNodes, nodesByIP, nil, 1, autoScalingOnTime), []string{"10.0.0.2"})
+	// the node idle for the longest time goes first
+	scheduler.scaleInPolicy = "least-recently-used"
+	scheduler.trackIdleNodes([]string{"10.0.0.3"}, autoScalingOnTime.Add(-time.Hour))
+	scheduler.trackIdleNodes(unusedNodes, autoScalingOnTime)
+	assert.Equal(t, scheduler.nodeIdleSince["10.0.0.3"], autoScalingOnTime.Add(-time.Hour))
+	assert.DeepEqual(t, scheduler.selectNodesToRemove(unusedNodes, nodesByIP, nil, 1, autoScalingOnTime), []string{"10.0.0.3"})
+	// a node which got pods is forgotten
+	scheduler.trackIdleNodes([]string{"10.0.0.2"}, autoScalingOnTime)
+	_, exist := scheduler.nodeIdleSince["10.0.0.3"]
+	assert.Assert(t, !exist)
+}
+
+func TestSelectNodesToRemoveFromZones(t *testing.T) {
+	scheduler := newFakeScheduler(newFakeNodePoolProvider(5), []apiv1.Pod{}, 5, 1, 1)
+	scheduler.scaleInPolicy = "oldest"
+	nodesByIP := map[string]*apiv1.Node{}
+	for nodeIP, age := range map[string]time.Duration{"10.0.0.2": time.Hour, "10.0.0.3": 2 * time.Hour, "10.0.0.5": 3 * time.Hour} {
+		nodesByIP[nodeIP] = newFakeNode(nodeIP, "4", "16Gi")
+		nodesByIP[nodeIP].CreationTimestamp = metav1.NewTime(autoScalingOnTime.Add(-age))
+	}
+	zoneNodes := map[string][]string{"tor01": {"10.0.0.1", "10.0.0.2", "10.0.0.3"}, "tor04": {"10.0.0.4", "10.0.0.5"}}
+	unusedNodes := []string{"10.0.0.2", "10.0.0.3", "10.0.0.5"}
+
+	// the oldest node is in the smallest zone, the oldest node of the largest zone goes first
+	assert.DeepEqual(t, scheduler.selectNodesToRemove(unusedNodes, nodesByIP, zoneNodes, 1, autoScalingOnTime), []string{"10.0.0.3"})
+	assert.DeepEqual(t, scheduler.selectNodesToRemove(unusedNodes, nodesByIP, zoneNodes, 3, autoScalingOnTime),
+		[]string{"10.0.0.3", "10.0.0.5", "10.0.0.2"})
+}
+
+func TestZoneAlignedTargetSize(t *testing.T) {
+	// 4 nodes in 3 zones grow to 6
+	assert.Equal(t, zoneAlignedTargetSize(4, 3, 3, 9), 6)
+	// rounded down when rounding up passes maxNode
+	assert.Equal(t, zoneAlignedTargetSize(7, 3, 3, 8), 6)
+	// not one node per zone fits
+	assert.Equal(t, zoneAlignedTargetSize(7, 6, 3, 8), 6)
+	// single zone and scale in are unchanged
+	assert.Equal(t, zoneAlignedTargetSize(4, 3, 0, 9), 4)
+	assert.Equal(t, zoneAlignedTargetSize(2, 3, 3, 9), 2)
+}
+
+func TestSimulationZonedPool(t *testing.T) {
+	provider := newFakeZonedNodePoolProvider([]string{"tor01", "tor04", "tor05"}, []int{1, 1, 1})
+	pods := []apiv1.Pod{newFakePod("worker-1", "10.0.0.1"), newFakePod("worker-2", "10.0.0.2"), newFakePod("worker-3", "10.0.0.3")}
+	scheduler := newFakeScheduler(provider, pods, 9, 1, 1)
+	addEvictionReactor(scheduler.clientSet.(*fake.Clientset), nil)
+
+	// 1 extra node is needed, the pool grows by one node per zone
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.DeepEqual(t, provider.resizeRequests, []int{6})
+	assert.Equal(t, len(provider.readyNodes()), 6)
+	zoneNodes := provider.ListZoneNodes("spark-worker")
+	for _, zone := range []string{"tor01", "tor04", "tor05"} {
+		assert.Equal(t, len(zoneNodes[zone]), 2)
+	}
+
+	// the 3 new nodes are unused, 2 are removed from different zones
+	scheduler.maxScaleStep = 2
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.Equal(t, len(provider.removeRequests), 2)
+	removedZones := map[string]bool{}
+	for zone, nodesIP := range zoneNodes {
+		for _, nodeIP := range nodesIP {
+			for _, removed := range provider.removeRequests {
+				if nodeIP == removed {
+					assert.Assert(t, !removedZones[zone])
+					removedZones[zone] = true
+				}
+			}
+		}
+	}
+}
@@ -1,140 +1,275 @@
 package cluster_controller
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"log"
 	"math"
-	"math/rand"
-	"os"
+	"runtime/debug"
+	"sync"
 	"time"
 )
 
 type Scheduler struct {
-	clusterClient	*IBMCloudClient
-	clientSet 		*kubernetes.Clientset
+	clusterClient	NodePoolProvider	//cloud provider that owns the workerPool
+	clientSet 		kubernetes.Interface
 	workerPool 		string		//name of the workerPool
+	nodePoolLabel	string		//label of the Node objects holding the name of their workerPool
 	nameSpace		string		//name of the namespace
+	podSelector		map[string]string	//labels of the pods of the workerPool
 	maxNode			int		//maximum nodes the workerPool is allowed to own
 	minNode			int 	//minimum nodes the workerPool is allowed to own
 	extraNode		int 	//extra idle nodes for additional usage
+	maxScaleStep	int		//maximum nodes added or removed in one round
+	scaleInPolicy	string		//policy choosing the unused nodes to remove, one of k8sutil.VictimPolicies
+	nodeIdleSince	map[string]time.Time	//since when every unused node has been idle, by internal IP
+	nodeUnregisteredSince	map[string]time.Time	//since when every node of the cloud has had no Node object, by internal IP
 	timeInterval	time.Duration		//time interval in SECONDS to check auto scaling
+	pollInterval	time.Duration	//time interval to check the workerPool size while it is being resized
+	scaleTimeout	time.Duration	//maximum time to wait for the workerPool being resized
+	drainTimeout	time.Duration	//maximum time to wait for the pods of a node being evicted before it is removed
+	location		*time.Location	//time zone of the auto scaling calender
+	calendar		Calendar	//auto scaling calender
+	calendarFile	string		//file the calender is reloaded from when it is modified, empty if the calender is static
+	calendarModTime	time.Time	//modification time of the calender file when it was loaded
+	dryRun			bool		//only log and count the scale actions instead of sending them to the cloud
+	dryRunIntent	k8sutil.DryRunIntent	//scale action intended by the last rounds in dry-run mode
 }
 
-func NewScheduler(ibmCloudClient *IBMCloudClient,k8ClientSet *kubernetes.Clientset,
-	workerPoolName string,nameSpace string, maxNodeNum int,minNodeNum int,extraNode int) *Scheduler {
-	return &Scheduler{
-		clusterClient:	ibmCloudClient,
+/*
+Create the Scheduler of the worker pool described by poolConfig, poolConfig is expected to be validated,
+an unknown time zone falls back to UTC and the default calender is used when poolConfig has none
+ */
+func NewScheduler(nodePoolProvider NodePoolProvider,k8ClientSet kubernetes.Interface, poolConfig PoolConfig) *Scheduler {
+	location, err := time.LoadLocation(poolConfig.TimeZone)
+	if err != nil {
+		log.Println("Unknown time zone", poolConfig.TimeZone, ", UTC is used instead:", err)
+		location = time.UTC
+	}
+	calendar := DefaultCalendar()
+	if poolConfig.Calendar != nil {
+		calendar = *poolConfig.Calendar
+	}
+	maxScaleStep := poolConfig.MaxScaleStep
+	if maxScaleStep < 1 {
+		maxScaleStep = 1
+	}
+	scaleInPolicy := poolConfig.ScaleInPolicy
+	if scaleInPolicy == "" {
+		scaleInPolicy = k8sutil.VictimPolicyRandom
+	}
+	nodePoolLabel := poolConfig.NodePoolLabel
+	if nodePoolLabel == "" {
+		nodePoolLabel = DefaultSchedulerConfig().NodePoolLabel
+	}
+	podSelector := poolConfig.PodSelector
+	if len(podSelector) == 0 {
+		podSelector = map[string]string{"pool": poolConfig.WorkerPool}
+	}
+	drainTimeout, err := time.ParseDuration(poolConfig.DrainTimeout)
+	if err != nil || drainTimeout < 0 {
+		drainTimeout = 5 * time.Minute
+	}
+	schedulerClient := &Scheduler{
+		clusterClient:	nodePoolProvider,
 		clientSet: 		k8ClientSet,
-		workerPool:		workerPoolName,
-		nameSpace:		nameSpace,
-		maxNode:		maxNodeNum,
-		minNode:		minNodeNum,
-		extraNode:		extraNode,
+		workerPool:		poolConfig.WorkerPool,
+		nodePoolLabel:	nodePoolLabel,
+		nameSpace:		poolConfig.Namespace,
+		podSelector:	podSelector,
+		maxNode:		poolConfig.MaxNode,
+		minNode:		poolConfig.MinNode,
+		extraNode:		poolConfig.ExtraNode,
+		maxScaleStep:	maxScaleStep,
+		scaleInPolicy:	scaleInPolicy,
+		nodeIdleSince:	map[string]time.Time{},
+		nodeUnregisteredSince:	map[string]time.Time{},
+		location:		location,
+		calendar:		calendar,
+		calendarFile:	poolConfig.CalendarFile,
+		dryRun:			poolConfig.DryRun != nil && *poolConfig.DryRun,
 		timeInterval:	15,
+		pollInterval:	10 * time.Second,
+		scaleTimeout:	10 * time.Minute,
+		drainTimeout:	drainTimeout,
 	}
+	schedulerClient.reloadCalendar()
+	return schedulerClient
 }
 
-// Structure for auto scaling calender, it manages the start and end time for each weekday,
-// for simplicity now, only one start hour and end hour are allowed to define
-type AutoScalingCalender struct {
-	startHour	int	//when auto scaling turns on
-	endHour		int	//when auto scaling turns off
-	weekday		time.Weekday //the weekday for this structure
-	wholeDayOn	bool //the entire day is on
+/*
+Evaluate the workerNodes/Pods usage condition in a worker pool and make the decision of adding/deleting worker nodes
+Current Algorithm is simple:
+	Checking all the Nodes and Pods in the workerPool, compute the size the workerPool needs for its pods and extra nodes,
+	then resize the workerPool to that size in one request, or drop unused nodes chosen by the scale in policy,
+	by at most maxScaleStep nodes
+
+AutoScale returns when ctx is cancelled. A resize request which is already accepted by the cloud is never rolled back,
+the cloud finishes it on its own, AutoScale only stops waiting for it. No new resize request is sent after ctx is cancelled,
+so the workerPool is either resized by the whole step or left untouched.
+ */
+func (schedulerClient *Scheduler) AutoScale(ctx context.Context, ignoreTimeSchedule bool){
+	log.Println("Time Zone is set to ",schedulerClient.location.String())
+	if schedulerClient.dryRun {
+		log.Println("Dry run: the scale actions of",schedulerClient.workerPool,"are only logged")
+	}
+	for {
+		schedulerClient.autoScaleRound(ctx,ignoreTimeSchedule)
+		//check after the interval
+		if !k8sutil.SleepWithContext(ctx,schedulerClient.timeInterval * time.Second) {
+			log.Println("Cluster AutoScaling is stopped")
+			return
+		}
+	}
 }
 
-// Initialize the calender when auto scaling being instantiated,
-// This is for husky practice and it is very project dependent
-func InitAutoScalingCalender()*[]AutoScalingCalender{
-	calenderList := []AutoScalingCalender{
-		// week day from 8pm to 6am auto scaling on
-		{startHour:20,endHour:6,weekday:time.Monday,wholeDayOn:false},
-		{startHour:20,endHour:6,weekday:time.Tuesday,wholeDayOn:false},
-		{startHour:20,endHour:6,weekday:time.Wednesday,wholeDayOn:false},
-		{startHour:20,endHour:6,weekday:time.Thursday,wholeDayOn:false},
-		{startHour:20,endHour:6,weekday:time.Friday,wholeDayOn:false},
-		//now weekends auto-scaling all on since why not
-		{startHour:0,endHour:0,weekday:time.Saturday,wholeDayOn:true},
-		{startHour:0,endHour:0,weekday:time.Sunday,wholeDayOn:true},
-	}
-	return &calenderList
+/*
+Auto scale every worker pool with its own Scheduler in its own goroutine, AutoScalePools returns once all of them
+are stopped by ctx. The worker pools are reconciled concurrently and a worker pool whose round fails doesn't delay
+or stop the other worker pools.
+ */
+func AutoScalePools(ctx context.Context, schedulers []*Scheduler, ignoreTimeSchedule bool) {
+	var waitGroup sync.WaitGroup
+	for _, schedulerClient := range schedulers {
+		waitGroup.Add(1)
+		go func(schedulerClient *Scheduler) {
+			defer waitGroup.Done()
+			schedulerClient.AutoScale(ctx,ignoreTimeSchedule)
+		}(schedulerClient)
+	}
+	waitGroup.Wait()
 }
 
 /*
-Evaluate the workerNodes/Pods usage condition in a worker pool and make the decision of adding/deleting worker nodes
-Current Algorithm is simple:
-	Checking all the Nodes and Pods in the workerPool, get the list of nodes that are not working, and drop one randomly,
-	further optimization will be made after version 1.0 is up and stable
+One round of auto scaling with the reloaded calender, a panic is recovered and counted so the failed round is
+skipped and the next rounds, and the other worker pools of the process, carry on
  */
-func (schedulerClient *Scheduler) AutoScale(ignoreTimeSchedule bool){
-	calender := InitAutoScalingCalender()
-	timeZone := os.Getenv("TIME_ZONE")
-	if timeZone == ""{
-		timeZone = "America/Edmonton"
-	}
-	loc ,_ := time.LoadLocation(timeZone)
-	log.Println("Time Zone is set to ",loc.String())
-	for {
-		// Check if auto scaling on
-		if AutoScaleByTime(calender,time.Now().In(loc)) || ignoreTimeSchedule {
-			// Get the list of nodes in	the workerPool
-			nodesList := schedulerClient.clusterClient.getWorkersNodesIP(schedulerClient.workerPool)
-			// Get the list of pods with matching node selector
-			nodeSelector := make(map[string]string)
-			nodeSelector["pool"] = schedulerClient.workerPool
-			podsList := schedulerClient.GetPodListWithLabels(schedulerClient.nameSpace,nodeSelector)
-			// podList nil means there is error getting the pod
-			if podsList == nil {
-				log.Println("Can't get pod list in the workerPool, skip this round")
-				time.Sleep(schedulerClient.timeInterval * time.Second) //check after the interval
-				continue
-			}
-			// It is a bit abnormal to have 0 pod in the cluster, but it is not fatal
-			if len(podsList) == 0 {
-				log.Println("Warning: podList is empty")
-			}
-			// In practice, the worker pool can't be empty
-			if len(nodesList) == 0 {
-				log.Println("worker pool should not have 0 nodes, skip this round")
-				time.Sleep(schedulerClient.timeInterval * time.Second) //check after the interval
-				continue
-			}
-			//Find unused nodes
-			unusedNodes := FindUnusedNodes(podsList,nodesList)
-			//Log message
-			schedulerClient.DebugMessage(nodesList,podsList,unusedNodes)
-			// Make decision to scale in or out the workerPool, using v1 algorithm
-			scaleOut,scaleIn,err := SparkAlgoV1(len(nodesList),int(math.Max(0,float64(len(unusedNodes)-FindPendingNodes(podsList)))),schedulerClient.extraNode,
-				schedulerClient.minNode,schedulerClient.maxNode)
+func (schedulerClient *Scheduler) autoScaleRound(ctx context.Context, ignoreTimeSchedule bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Auto scaling round of %s failed: %v\n%s",schedulerClient.workerPool,r,debug.Stack())
+			roundFailures.WithLabelValues(schedulerClient.workerPool).Inc()
+		}
+	}()
+	schedulerClient.reloadCalendar()
+	schedulerClient.autoScaleOnce(ctx,schedulerClient.calendar,time.Now().In(schedulerClient.location),ignoreTimeSchedule)
+}
+
+/*
+One round of auto scaling: check the calender, evaluate the workerPool and scale it in or out to its target size if needed.
+The node numbers overridden by the active window of the calender are used while auto scaling is on.
+
+Input
+-----
+ctx: the scaling action is skipped once ctx is cancelled
+calendar: auto scaling schedule
+timeNow: current time in the scheduler's time zone
+ignoreTimeSchedule: force auto scaling to be on
+
+Output
+------
+None
+ */
+func (schedulerClient *Scheduler) autoScaleOnce(ctx context.Context, calendar Calendar, timeNow time.Time, ignoreTimeSchedule bool){
+	// Check if auto scaling on
+	window,scheduleOn := calendar.ActiveWindow(timeNow)
+	minNode,maxNode,extraNode := window.nodeBounds(schedulerClient.minNode,schedulerClient.maxNode,schedulerClient.extraNode)
+	scheduleOnGauge.WithLabelValues(schedulerClient.workerPool).Set(boolValue(scheduleOn))
+	dryRunGauge.WithLabelValues(schedulerClient.workerPool).Set(boolValue(schedulerClient.dryRun))
+	schedulerClient.dryRunIntent.NextRound()
+	if scheduleOn || ignoreTimeSchedule {
+		// Get the list of nodes in	the workerPool
+		nodesList := schedulerClient.clusterClient.ListNodes(schedulerClient.workerPool)
+		// Get the list of pods with matching node selector
+		podsList := schedulerClient.GetPodListWithLabels(schedulerClient.nameSpace,schedulerClient.podSelector)
+		// podList nil means there is error getting the pod
+		if podsList == nil {
+			log.Println("Can't get pod list in the workerPool, skip this round")
+			return
+		}
+		// It is a bit abnormal to have 0 pod in the cluster, but it is not fatal
+		if len(podsList) == 0 {
+			log.Println("Warning: podList is empty")
+		}
+		// In practice, the worker pool can't be empty
+		if len(nodesList) == 0 {
+			log.Println("worker pool should not have 0 nodes, skip this round")
+			return
+		}
+		// Read the Node objects of the workerPool to find the pods of all namespaces running on its nodes
+		nodesByIP,err := schedulerClient.poolNodesByIP()
+		if err != nil {
+			log.Println("Can't get the Node objects of the workerPool:",err)
+		}
+		// Read the pods of all namespaces on these nodes once, for the unused nodes and the packing of the pending pods
+		var nodePods []apiv1.Pod
+		if len(nodesByIP) > 0 {
+			nodePods,err = schedulerClient.poolNodePods(nodesByIP)
 			if err != nil {
-				log.Println(err)
-				time.Sleep(schedulerClient.timeInterval * time.Second) //check after the interval
-				continue
-			}
-			if scaleIn{
-				removeIndex := rand.Intn(len(unusedNodes))                                   //randomly pick a node to drop
-				schedulerClient.ScaleIn(schedulerClient.workerPool,unusedNodes[removeIndex]) //remove the pod
-			}else if scaleOut{
-				schedulerClient.ScaleOut(schedulerClient.workerPool)
-			}
-		}else{
-			// Auto scaling mode off, turn on maximum number of allowed worker nodes
-			log.Println("Cluster AutoScaling is OFF, set the nodes number to max")
-			nodesList := schedulerClient.clusterClient.getWorkersNodesIP(schedulerClient.workerPool)
-			if len(nodesList) == 0 {
-				log.Println("Warning: Node list is empty, skip this round")
-				time.Sleep(schedulerClient.timeInterval * time.Second) //check after the interval
-				continue
+				log.Println("Can't get the pods of the cluster:",err)
 			}
-			if len(nodesList) < schedulerClient.maxNode {
-				schedulerClient.ScaleOut(schedulerClient.workerPool)
+		}
+		// Read the zones of a multi-zone workerPool, to keep them balanced
+		zoneNodes := schedulerClient.listZoneNodes()
+		//Find unused nodes
+		unusedNodes := schedulerClient.unusedNodes(nodesList,nodesByIP,nodePods,podsList,timeNow)
+		schedulerClient.trackIdleNodes(unusedNodes,timeNow)
+		//Log message
+		schedulerClient.DebugMessage(nodesList,podsList,unusedNodes)
+		nodesGauge.WithLabelValues(schedulerClient.workerPool).Set(float64(len(nodesList)))
+		unusedNodesGauge.WithLabelValues(schedulerClient.workerPool).Set(float64(len(unusedNodes)))
+		pendingPodsGauge.WithLabelValues(schedulerClient.workerPool).Set(float64(FindPendingNodes(podsList)))
+		// Simulate the scheduling of the pending pods, unless the capacity of the nodes is unknown,
+		// then every pending pod needs a node
+		idleNodes,numOfPendingPods := unusedNodes,FindPendingNodes(podsList)
+		if packing,ok := schedulerClient.packPendingPods(nodesList,nodesByIP,nodePods,pendingPods(podsList)); ok {
+			// the unused nodes receiving pending pods are no longer idle nor removed, and the new nodes are all taken
+			idleNodes,numOfPendingPods = []string{},packing.newNodes
+			for _, nodeIP := range unusedNodes {
+				if !packing.filledNodes[nodeIP] {idleNodes = append(idleNodes,nodeIP)}
 			}
+			log.Println("Pending pods need",packing.newNodes,"new nodes,",packing.skippedPods,"pending pods are skipped")
+		}
+		// Make decision to scale in or out the workerPool, using v2 algorithm
+		targetSize,err := SparkAlgoV2(len(nodesList),len(idleNodes),numOfPendingPods,extraNode,
+			minNode,maxNode,schedulerClient.maxScaleStep)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		targetSize = zoneAlignedTargetSize(targetSize,len(nodesList),len(zoneNodes),maxNode)
+		targetNodesGauge.WithLabelValues(schedulerClient.workerPool).Set(float64(targetSize))
+		decisionGauge.WithLabelValues(schedulerClient.workerPool).Set(decisionValue(targetSize > len(nodesList),targetSize < len(nodesList)))
+		if targetSize < len(nodesList) {
+			//pick the nodes to drop with the scale in policy, only the idle nodes can be dropped
+			numToRemove := int(math.Min(float64(len(nodesList)-targetSize),float64(len(idleNodes))))
+			nodesToRemove := schedulerClient.selectNodesToRemove(idleNodes,nodesByIP,zoneNodes,numToRemove,timeNow)
+			schedulerClient.ScaleIn(ctx,schedulerClient.workerPool,nodesToRemove)
+		}else if targetSize > len(nodesList) {
+			schedulerClient.ScaleOut(ctx,schedulerClient.workerPool,targetSize)
+		}
+	}else{
+		// Auto scaling mode off, turn on maximum number of allowed worker nodes
+		log.Println("Cluster AutoScaling is OFF, set the nodes number to max")
+		nodesList := schedulerClient.clusterClient.ListNodes(schedulerClient.workerPool)
+		if len(nodesList) == 0 {
+			log.Println("Warning: Node list is empty, skip this round")
+			return
+		}
+		targetSize := int(math.Min(float64(schedulerClient.maxNode),float64(len(nodesList)+schedulerClient.maxScaleStep)))
+		targetSize = zoneAlignedTargetSize(targetSize,len(nodesList),len(schedulerClient.listZoneNodes()),schedulerClient.maxNode)
+		nodesGauge.WithLabelValues(schedulerClient.workerPool).Set(float64(len(nodesList)))
+		targetNodesGauge.WithLabelValues(schedulerClient.workerPool).Set(float64(targetSize))
+		decisionGauge.WithLabelValues(schedulerClient.workerPool).Set(decisionValue(len(nodesList) < targetSize,false))
+		if len(nodesList) < targetSize {
+			schedulerClient.ScaleOut(ctx,schedulerClient.workerPool,targetSize)
 		}
-		time.Sleep(schedulerClient.timeInterval * time.Second) //check after the interval
 	}
 }
 
@@ -147,127 +282,237 @@ Version 1.0 Cluster AutoScaling Algorithm:
 4. If # of unused nodes < # extra nodes && # of nodes < # max nodes , add 1 node
 5. If # of nodes < min nodes + extra, add 1 node
 6. If # of nodes > max nodes, best effort to drop the node
+
+numOfUnusedNodes is expected to exclude the unused nodes which will be taken by the pending pods.
+It is the direction of SparkAlgoV2 with a step of one node.
  */
 func SparkAlgoV1(numOfNodes int, numOfUnusedNodes int, numofExtraNodes int, minNodes int, maxNodes int) (scaleUp bool,
 	scaleDown bool, err error) {
+	targetSize, err := SparkAlgoV2(numOfNodes, numOfUnusedNodes, 0, numofExtraNodes, minNodes, maxNodes, 1)
+	if err != nil {
+		return false, false, err
+	}
+	return targetSize > numOfNodes, targetSize < numOfNodes, nil
+}
+
+/*
+Version 2.0 Cluster AutoScaling Algorithm, the rules of version 1.0 applied to the size of the workerPool at once:
+1. Every pending pod needs a node, it takes an unused node first, then a new node is needed
+2. The target size is the nodes in use + the new nodes needed by the pending pods + # of extra nodes
+3. The target size is at least # of min nodes + # extra nodes and at most # max nodes
+4. The target size differs from # of nodes by at most maxStep nodes, 0 means no limit
+
+Input
+-----
+numOfNodes: nodes in the workerPool, including the ones being provisioned
+numOfUnusedNodes: nodes without any pod of the workerPool
+numOfPendingPods: pods of the workerPool which are not assigned to a node
+numofExtraNodes, minNodes, maxNodes: node numbers of the workerPool
+maxStep: maximum nodes added or removed at once
+
+Output
+------
+the target size of the workerPool, or an error for inconsistent inputs
+ */
+func SparkAlgoV2(numOfNodes int, numOfUnusedNodes int, numOfPendingPods int, numofExtraNodes int, minNodes int,
+	maxNodes int, maxStep int) (targetSize int, err error) {
 	e:= errors.New
-	if minNodes > maxNodes {return false,false,e("'MinNode can't be larger than MaxNode")}
-	if minNodes + numofExtraNodes > maxNodes{return false,false,e("min + extra can't > max")}
-	if numOfNodes < numOfUnusedNodes {return false,false,e("num of unusedNodes can't be larger" +
+	if minNodes > maxNodes {return numOfNodes,e("'MinNode can't be larger than MaxNode")}
+	if minNodes + numofExtraNodes > maxNodes{return numOfNodes,e("min + extra can't > max")}
+	if numOfNodes < numOfUnusedNodes {return numOfNodes,e("num of unusedNodes can't be larger" +
 		"then total nodes")}
-	if numOfNodes < (minNodes + numofExtraNodes) {
-		return true,false,nil
+	targetSize = numOfNodes - numOfUnusedNodes + numOfPendingPods + numofExtraNodes
+	if targetSize < minNodes + numofExtraNodes {
+		targetSize = minNodes + numofExtraNodes
+	}
+	if targetSize > maxNodes {
+		targetSize = maxNodes
 	}
-	if numOfUnusedNodes < numofExtraNodes && numOfNodes < maxNodes{
-		return true,false,nil
+	if maxStep > 0 && targetSize > numOfNodes + maxStep {
+		targetSize = numOfNodes + maxStep
 	}
-	if numOfUnusedNodes > numofExtraNodes && numOfNodes > (minNodes + numofExtraNodes) {
-		return false,true,nil
+	if maxStep > 0 && targetSize < numOfNodes - maxStep {
+		targetSize = numOfNodes - maxStep
 	}
-	return false,false,nil
+	return targetSize, nil
 }
+
 /*
-Randomly remove an unused worker Nodes in a workerPool
+Remove unused worker Nodes in a workerPool, then wait until all the accepted removals are done.
+Every node is cordoned and drained first, a node which can't be drained in time, or whose removal is rejected
+by the cloud, is uncordoned and kept. Once ctx is cancelled, the drained nodes not removed yet are uncordoned and
+kept too. A node which was already cordoned before, e.g. by an admin, stays cordoned.
 
 Input
 -----
+ctx: stop waiting for the nodes being removed once ctx is cancelled
 workerpoolName:	name of the worker pool
-nodeIP: Internal IP address of the node
+nodeIPs: Internal IP addresses of the nodes
 
 Output
 ------
 None
  */
-func (schedulerClient *Scheduler) ScaleIn(workerpoolName string, nodeIP string) {
+func (schedulerClient *Scheduler) ScaleIn(ctx context.Context, workerpoolName string, nodeIPs []string) {
 	//first try to get the cluster information to exclude network issue
-	testInternetConnection := schedulerClient.clusterClient.getClusterResourceGroup()
-	if testInternetConnection == ""{
+	if !schedulerClient.clusterClient.HealthCheck() {
 		log.Println("ScaleIn: network problem")
 		return
 	}
-	prevSize := len(schedulerClient.clusterClient.getWorkersNodesIP(schedulerClient.workerPool))
+	prevSize := len(schedulerClient.clusterClient.ListNodes(schedulerClient.workerPool))
 	if prevSize == 0{
 		log.Println("Warning: the node list can't empty, skip the action")
 		return
 	}
-	succeed := schedulerClient.clusterClient.removeWorker(workerpoolName,nodeIP)
-	if !succeed {
-		log.Printf("Node %s in %s can not be removed\n",nodeIP,workerpoolName)
-	}else {
-		log.Printf("Node %s in %s is being removed\n", nodeIP, workerpoolName)
-		timeBegin := time.Now()
-		for {
-			nodes := schedulerClient.clusterClient.getWorkersNodesIP(schedulerClient.workerPool)
-			currSize := len(nodes)	// get the current size
-			//TODO: rework on the logic for next version, cuz now any inference on cluster ui might cause an issue
-			if currSize == prevSize - 1 {
-				canBreak := true
-				for _,val := range nodes {
-					if val == nodeIP {canBreak = false}
-				}
-				if canBreak {
-					log.Println("Removed worker node takes: ",time.Now().Sub(timeBegin).Minutes()," mins")
-					break
-				}
+	if len(nodeIPs) == 0 {
+		log.Println("ScaleIn: no unused node can be removed, skip the action")
+		return
+	}
+	if ctx.Err() != nil {
+		log.Println("ScaleIn: shutting down, skip the action")
+		return
+	}
+	if schedulerClient.dryRun {
+		for _, nodeIP := range nodeIPs {
+			log.Printf("Dry run: node %s in %s would be drained and removed\n",nodeIP,workerpoolName)
+		}
+		log.Printf("Dry run: %d nodes would be left in %s\n",prevSize-len(nodeIPs),workerpoolName)
+		// the same scale in is intended at every round while the load doesn't change, it is counted once
+		if schedulerClient.dryRunIntent.Intend(fmt.Sprintf("%s to %d nodes",dryRunActionScaleIn,prevSize-len(nodeIPs))) {
+			dryRunActions.WithLabelValues(workerpoolName,dryRunActionScaleIn).Add(float64(len(nodeIPs)))
+		}
+		return
+	}
+	removing := map[string]bool{}
+	drained,cordoned := schedulerClient.drainNodes(ctx,nodeIPs)
+	for i, nodeIP := range drained {
+		if ctx.Err() != nil {
+			// the nodes left are drained but not removed, they get pods again unless they were cordoned before
+			log.Printf("ScaleIn: shutting down, %d drained nodes in %s are kept\n",len(drained)-i,workerpoolName)
+			for _, keptIP := range drained[i:] {
+				if cordoned[keptIP] {schedulerClient.uncordonNode(keptIP)}
+			}
+			break
+		}
+		succeed := schedulerClient.clusterClient.RemoveNode(workerpoolName,nodeIP)
+		if !succeed {
+			log.Printf("Node %s in %s can not be removed\n",nodeIP,workerpoolName)
+			if cordoned[nodeIP] {schedulerClient.uncordonNode(nodeIP)}
+		}else {
+			log.Printf("Node %s in %s is being removed\n", nodeIP, workerpoolName)
+			removing[nodeIP] = true
+		}
+	}
+	if len(removing) == 0 {
+		return
+	}
+	timeBegin := time.Now()
+	for {
+		nodes := schedulerClient.clusterClient.ListNodes(schedulerClient.workerPool)
+		currSize := len(nodes)	// get the current size
+		//TODO: rework on the logic for next version, cuz now any inference on cluster ui might cause an issue
+		if currSize == prevSize - len(removing) {
+			canBreak := true
+			for _,val := range nodes {
+				if removing[val] {canBreak = false}
+			}
+			if canBreak {
+				log.Println("Removed",len(removing),"worker nodes takes: ",time.Now().Sub(timeBegin).Minutes()," mins")
+				scaleInDuration.WithLabelValues(workerpoolName,scaleResultConverged).Observe(time.Since(timeBegin).Seconds())
+				break
 			}
-			if int(time.Now().Sub(timeBegin).Minutes()) > 10 {break;}
+		}
+		if time.Now().Sub(timeBegin) > schedulerClient.scaleTimeout {
+			scaleInDuration.WithLabelValues(workerpoolName,scaleResultTimeout).Observe(time.Since(timeBegin).Seconds())
+			break
+		}
+		if !k8sutil.SleepWithContext(ctx,schedulerClient.pollInterval) {
+			log.Printf("Shutting down, %d nodes in %s will be removed by the cloud without waiting\n", len(removing), workerpoolName)
+			scaleInDuration.WithLabelValues(workerpoolName,scaleResultCancelled).Observe(time.Since(timeBegin).Seconds())
+			break
 		}
 	}
 }
 
 /*
-Add a worker node in a worker pool
+Resize a worker pool to targetSize nodes in one request, then wait until all the new nodes are provisioned
 
 Input
 -----
+ctx: stop waiting for the nodes being added once ctx is cancelled
 workerpoolName:	name of the worker pool
+targetSize: number of nodes of the worker pool after the resize
 
 Output
 ------
 None
 */
-func (schedulerClient *Scheduler) ScaleOut(workerpoolName string) {
+func (schedulerClient *Scheduler) ScaleOut(ctx context.Context, workerpoolName string, targetSize int) {
 	// Get the current size of the node list
-	testInternetConnection := schedulerClient.clusterClient.getClusterResourceGroup()
-	if testInternetConnection == ""{
+	if !schedulerClient.clusterClient.HealthCheck() {
 		log.Println("ScaleOut: network problem")
 		return
 	}
-	prevSize := len(schedulerClient.clusterClient.getWorkersNodesIP(schedulerClient.workerPool))
+	prevSize := len(schedulerClient.clusterClient.ListNodes(schedulerClient.workerPool))
 	if prevSize == 0{
 		log.Println("Warning: the node list is empty, skip the action")
 		return
 	}
-	succeed := schedulerClient.clusterClient.addOneWorker(workerpoolName)
+	if prevSize >= targetSize {
+		log.Printf("ScaleOut: %s already has %d nodes, skip the action\n",workerpoolName,prevSize)
+		return
+	}
+	if ctx.Err() != nil {
+		log.Println("ScaleOut: shutting down, skip the action")
+		return
+	}
+	if schedulerClient.dryRun {
+		log.Printf("Dry run: %s would be resized from %d to %d nodes\n",workerpoolName,prevSize,targetSize)
+		if schedulerClient.dryRunIntent.Intend(fmt.Sprintf("%s to %d nodes",dryRunActionScaleOut,targetSize)) {
+			dryRunActions.WithLabelValues(workerpoolName,dryRunActionScaleOut).Inc()
+		}
+		return
+	}
+	succeed := schedulerClient.clusterClient.ResizePool(workerpoolName,targetSize)
 	if !succeed {
-		log.Println("Can not add a new worker node")
+		log.Printf("Can not resize %s from %d to %d nodes\n",workerpoolName,prevSize,targetSize)
 	}else{
-		log.Println("Adding a new worker node")
+		log.Printf("Adding %d worker nodes to %s\n",targetSize-prevSize,workerpoolName)
 		timeBegin := time.Now()
 		for {
-			nodes := schedulerClient.clusterClient.getWorkersNodesIP(schedulerClient.workerPool)
+			nodes := schedulerClient.clusterClient.ListNodes(schedulerClient.workerPool)
 			currSize := len(nodes)	// get the current size
 			// TODO : also this part, same as scale in
-			if currSize == prevSize + 1{
+			// a multi-zone worker pool can grow past targetSize when its zones are unbalanced
+			if currSize >= targetSize{
 				canBreak := true
 				for _,val := range nodes {
 					if val == ""{ canBreak = false}
 				}
 				if canBreak {
-					log.Println("Added a new worker node takes: ",time.Now().Sub(timeBegin).Minutes()," mins")
+					log.Println("Added",targetSize-prevSize,"worker nodes takes: ",time.Now().Sub(timeBegin).Minutes()," mins")
+					scaleOutDuration.WithLabelValues(workerpoolName,scaleResultConverged).Observe(time.Since(timeBegin).Seconds())
 					break
 				}
 			}
-			if int(time.Now().Sub(timeBegin).Minutes()) > 10 {break;}
+			if time.Now().Sub(timeBegin) > schedulerClient.scaleTimeout {
+				scaleOutDuration.WithLabelValues(workerpoolName,scaleResultTimeout).Observe(time.Since(timeBegin).Seconds())
+				break
+			}
+			if !k8sutil.SleepWithContext(ctx,schedulerClient.pollInterval) {
+				log.Println("Shutting down, the new worker nodes will be added by the cloud without waiting")
+				scaleOutDuration.WithLabelValues(workerpoolName,scaleResultCancelled).Observe(time.Since(timeBegin).Seconds())
+				break
+			}
 		}
 	}
 }
 
 /*
-Returns a list of unused worker nodes to be removed in a workerpool,
-Note: the unused nodes can be:
-1. nodes that are active but idle in the workerPool
-2. nodes that are still being provisioned so can't be used by any pod
+Returns a list of unused worker nodes to be removed in a workerpool, by matching the internal IP of the nodes with
+the node names of the pods, which only works when the Node objects are named after their internal IP.
+The nodes still being provisioned have no IP yet and are not returned.
+It is only used when the Node objects of the workerPool can't be read, see findUnusedPoolNodes.
 
 Input
 -----
@@ -313,43 +558,6 @@ func FindPendingNodes(podlists []apiv1.Pod) int{
 	return count
 }
 
-/*
-This function control the period and ON/OFF of auto scaling functions,
-in the future if more schedulers are added, we need to write it as a struct for each schedulars
-
-Input
------
-calendar: Monday to Friday auto scaling schedule
-timeNow: current time of the call
-
-Output
-------
-boolean that decides should auto scaling turns on.
- */
-func AutoScaleByTime(calendar *[]AutoScalingCalender, timeNow time.Time)bool{
-	for _, val := range *calendar{
-		// find the matching weekday
-		if val.weekday == timeNow.Weekday() {
-			// always turns on for the whole up date
-			if val.wholeDayOn {return true}
-			// two case:
-			// 1. start and end time on the same date,
-			// 2. start one day and end on the other date
-
-			// same date
-			if val.startHour < val.endHour {
-				if timeNow.Hour() < val.endHour && timeNow.Hour() >= val.startHour {return true}
-				return false
-			} else if val.startHour > val.endHour {
-				// midnight between start and end hours
-				if timeNow.Hour() >= val.startHour || timeNow.Hour() < val.endHour {return true}
-				return false
-			}
-		}
-	}
-	return false
-}
-
 /*
 Return the list of pods with matching labels
 
@@ -379,6 +587,10 @@ func (schedulerClient *Scheduler)GetPodListWithLabels(namespace string, targetLa
 		})
 		attempts = attempts+1
 	}
+	// an empty list is not an error, make sure it is not mistaken for nil
+	if pods.Items == nil {
+		return []apiv1.Pod{}
+	}
 	return pods.Items
 }
 
@@ -0,0 +1,276 @@
+package cluster_controller
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+// Saturday, auto scaling is on for the whole day in the default calender
+var autoScalingOnTime = time.Date(2019, time.June, 22, 12, 0, 0, 0, time.UTC)
+
+// Monday noon, auto scaling is off in the default calender
+var autoScalingOffTime = time.Date(2019, time.June, 17, 12, 0, 0, 0, time.UTC)
+
+func TestSimulationScaleOutWhenNoIdleNode(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	provider.provisionPolls = 2
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+		newFakePod("worker-2", "10.0.0.2"),
+		newFakePod("worker-3", "10.0.0.3"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 5, 1, 1)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// one node is added and ScaleOut waited until it got its IP
+	assert.DeepEqual(t, provider.resizeRequests, []int{4})
+	assert.Assert(t, len(provider.readyNodes()) == 4)
+}
+
+func TestSimulationScaleInIdleNode(t *testing.T) {
+	provider := newFakeNodePoolProvider(5)
+	provider.deletePolls = 2
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+		newFakePod("worker-2", "10.0.0.2"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 9, 1, 1)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// 3 idle nodes while only 1 extra node is needed, one of the idle nodes is removed
+	assert.Assert(t, len(provider.removeRequests) == 1)
+	assert.Assert(t, provider.removeRequests[0] != "10.0.0.1" && provider.removeRequests[0] != "10.0.0.2")
+	assert.Assert(t, len(provider.readyNodes()) == 4)
+}
+
+func TestSimulationConvergesToExtraIdleNodes(t *testing.T) {
+	provider := newFakeNodePoolProvider(6)
+	provider.provisionPolls = 1
+	provider.deletePolls = 1
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+		newFakePod("worker-2", "10.0.0.2"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 9, 1, 1)
+	for round := 0; round < 10; round++ {
+		scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	}
+	// 2 busy nodes and 1 idle node are kept
+	assert.Assert(t, len(provider.readyNodes()) == 3)
+	assert.Assert(t, len(provider.removeRequests) == 3)
+	assert.Assert(t, len(provider.resizeRequests) == 0)
+}
+
+func TestSimulationPendingPodsTriggerScaleOut(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+		newFakePod("worker-2", "10.0.0.2"),
+		newFakePod("worker-3", ""),
+	}
+	scheduler := newFakeScheduler(provider, pods, 5, 1, 1)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// the idle node will be taken by the pending pod, so another node is needed
+	assert.DeepEqual(t, provider.resizeRequests, []int{4})
+	assert.Assert(t, len(provider.removeRequests) == 0)
+}
+
+func TestSimulationPendingPodsResizeInOneRequest(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	provider.provisionPolls = 2
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+		newFakePod("worker-2", "10.0.0.2"),
+		newFakePod("worker-3", "10.0.0.3"),
+		newFakePod("worker-4", ""),
+		newFakePod("worker-5", ""),
+		newFakePod("worker-6", ""),
+		newFakePod("worker-7", ""),
+	}
+	scheduler := newFakeScheduler(provider, pods, 10, 1, 1)
+	scheduler.maxScaleStep = 3
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// 4 pending pods and 1 extra node need 5 new nodes, the step limits the resize to 3
+	assert.DeepEqual(t, provider.resizeRequests, []int{6})
+	assert.Assert(t, len(provider.readyNodes()) == 6)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.DeepEqual(t, provider.resizeRequests, []int{6, 8})
+}
+
+func TestSimulationScaleInSeveralIdleNodes(t *testing.T) {
+	provider := newFakeNodePoolProvider(6)
+	provider.deletePolls = 2
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 9, 1, 1)
+	scheduler.maxScaleStep = 5
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// 5 idle nodes while only 1 extra node is needed, 4 of them are removed in one round
+	assert.Assert(t, len(provider.removeRequests) == 4)
+	for _, nodeIP := range provider.removeRequests {
+		assert.Assert(t, nodeIP != "10.0.0.1")
+	}
+	assert.Assert(t, len(provider.readyNodes()) == 2)
+}
+
+func TestSimulationScheduleOffScalesToMaxByStep(t *testing.T) {
+	provider := newFakeNodePoolProvider(2)
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{}, 7, 1, 1)
+	scheduler.maxScaleStep = 3
+	for round := 0; round < 3; round++ {
+		scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOffTime, false)
+	}
+	assert.DeepEqual(t, provider.resizeRequests, []int{5, 7})
+}
+
+func TestSimulationFailedDeletionKeepsNode(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	provider.failRemove["10.0.0.2"] = true
+	provider.failRemove["10.0.0.3"] = true
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 5, 0, 1)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.Assert(t, len(provider.removeRequests) == 0)
+	assert.Assert(t, len(provider.readyNodes()) == 3)
+}
+
+func TestSimulationUnhealthyProviderSkipsAction(t *testing.T) {
+	provider := newFakeNodePoolProvider(3)
+	provider.unhealthy = true
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{}, 5, 4, 1)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.Assert(t, len(provider.resizeRequests) == 0)
+	assert.Assert(t, len(provider.readyNodes()) == 3)
+}
+
+func TestSimulationScheduleOffScalesToMax(t *testing.T) {
+	provider := newFakeNodePoolProvider(2)
+	provider.provisionPolls = 3
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{}, 4, 1, 1)
+	for round := 0; round < 5; round++ {
+		scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOffTime, false)
+	}
+	assert.DeepEqual(t, provider.resizeRequests, []int{3, 4})
+	assert.Assert(t, len(provider.readyNodes()) == 4)
+
+	// ignoring the schedule makes the idle nodes removable again
+	for round := 0; round < 5; round++ {
+		scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOffTime, true)
+	}
+	assert.Assert(t, len(provider.readyNodes()) == 2)
+}
+
+func TestSimulationScaleOutTimeout(t *testing.T) {
+	provider := newFakeNodePoolProvider(1)
+	// the new node never finishes provisioning
+	provider.provisionPolls = 1 << 30
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{newFakePod("worker-1", "10.0.0.1")}, 3, 1, 1)
+	scheduler.scaleTimeout = 20 * time.Millisecond
+	timeBegin := time.Now()
+	scheduler.ScaleOut(context.Background(), scheduler.workerPool, 2)
+	assert.Assert(t, time.Since(timeBegin) < time.Second)
+	assert.DeepEqual(t, provider.resizeRequests, []int{2})
+}
+
+func TestSimulationCancelledScaleOutIsNotWaited(t *testing.T) {
+	provider := newFakeNodePoolProvider(1)
+	provider.provisionPolls = 1 << 30
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{newFakePod("worker-1", "10.0.0.1")}, 3, 1, 1)
+	scheduler.pollInterval = time.Hour
+	scheduler.scaleTimeout = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	// the resize request is sent, then the wait is abandoned when ctx is cancelled
+	scheduler.ScaleOut(ctx, scheduler.workerPool, 2)
+	assert.DeepEqual(t, provider.resizeRequests, []int{2})
+	// no new request after ctx is cancelled
+	scheduler.ScaleOut(ctx, scheduler.workerPool, 2)
+	assert.DeepEqual(t, provider.resizeRequests, []int{2})
+}
+
+func TestAutoScaleReturnsOnCancel(t *testing.T) {
+	provider := newFakeNodePoolProvider(2)
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{}, 3, 1, 1)
+	scheduler.timeInterval = 3600
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		scheduler.AutoScale(ctx, true)
+		done <- true
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AutoScale did not return after ctx is cancelled")
+	}
+}
+
+func TestSimulationDryRunOnlyCountsActions(t *testing.T) {
+	provider := newFakeNodePoolProvider(5)
+	pods := []apiv1.Pod{
+		newFakePod("worker-1", "10.0.0.1"),
+	}
+	scheduler := newFakeScheduler(provider, pods, 6, 1, 1)
+	scheduler.workerPool = "dry-run-test"
+	scheduler.dryRun = true
+	// the counters are global, only what this scenario adds is checked
+	scaleInActions := testutil.ToFloat64(dryRunActions.WithLabelValues("dry-run-test", dryRunActionScaleIn))
+	scaleOutActions := testutil.ToFloat64(dryRunActions.WithLabelValues("dry-run-test", dryRunActionScaleOut))
+	// the same scale in intended by two rounds is counted once
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	// the schedule is off, the pool would be scaled out to its maximum
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOffTime, false)
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOffTime, false)
+	assert.Assert(t, len(provider.removeRequests) == 0)
+	assert.Assert(t, len(provider.resizeRequests) == 0)
+	assert.Assert(t, len(provider.readyNodes()) == 5)
+	assert.Equal(t, testutil.ToFloat64(dryRunGauge.WithLabelValues("dry-run-test")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(dryRunActions.WithLabelValues("dry-run-test", dryRunActionScaleIn)), scaleInActions+1)
+	assert.Equal(t, testutil.ToFloat64(dryRunActions.WithLabelValues("dry-run-test", dryRunActionScaleOut)), scaleOutActions+1)
+	// the scale in intended again after another decision is counted again
+	scheduler.autoScaleOnce(context.Background(), DefaultCalendar(), autoScalingOnTime, false)
+	assert.Equal(t, testutil.ToFloat64(dryRunActions.WithLabelValues("dry-run-test", dryRunActionScaleIn)), scaleInActions+2)
+}
+
+func TestSimulationAutoScalePools(t *testing.T) {
+	provider := newFakeNodePoolProvider(1)
+	pod := newFakePod("jupyter-1", "10.0.0.1")
+	pod.Labels = map[string]string{"component": "singleuser-server"}
+	scheduler := newFakeScheduler(provider, []apiv1.Pod{pod}, 5, 1, 1)
+	scheduler.workerPool = "pools-healthy"
+	scheduler.podSelector = pod.Labels
+	brokenProvider := newFakeNodePoolProvider(1)
+	brokenProvider.broken = true
+	brokenScheduler := newFakeScheduler(brokenProvider, []apiv1.Pod{}, 5, 1, 1)
+	brokenScheduler.workerPool = "pools-broken"
+	healthyFailures := testutil.ToFloat64(roundFailures.WithLabelValues("pools-healthy"))
+	brokenFailures := testutil.ToFloat64(roundFailures.WithLabelValues("pools-broken"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan bool)
+	go func() {
+		AutoScalePools(ctx, []*Scheduler{brokenScheduler, scheduler}, true)
+		stopped <- true
+	}()
+	// the pods are found by the selector of the worker pool, which gets its extra node although the other worker
+	// pool panics in every round
+	timeout := time.Now().Add(5 * time.Second)
+	for len(provider.readyNodes()) < 2 || testutil.ToFloat64(roundFailures.WithLabelValues("pools-broken")) < brokenFailures+1 {
+		assert.Assert(t, time.Now().Before(timeout), "the worker pools were not auto scaled")
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-stopped
+	assert.DeepEqual(t, provider.resizeRequests, []int{2})
+	assert.Equal(t, testutil.ToFloat64(roundFailures.WithLabelValues("pools-healthy")), healthyFailures)
+}
@@ -5,7 +5,6 @@ import (
 	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
 	"gotest.tools/assert"
 	apiv1 "k8s.io/api/core/v1"
-	"time"
 
 	//"gotest.tools/assert"
 	"testing"
@@ -13,6 +12,7 @@ import (
 
 //Test in Sandbox env, need kubernetes sandbox yml
 func TestGetPodListWithLabelsSandbox(t *testing.T) {
+	skipLiveTest(t)
 	scheduler := Scheduler{
 		clientSet:  k8sutil.InitializeClient(false),
 		workerPool: "kubernetes.io/hostname: 10.166.255.114",
@@ -41,44 +41,6 @@ func TestGetPodListWithLabelsSandbox(t *testing.T) {
 //	}
 //}
 
-//test auto scale by time
-func TestAutoScaleByTime(t *testing.T){
-	calenderList := []AutoScalingCalender{
-		{startHour:20,endHour:6,weekday:time.Monday,wholeDayOn:false},
-		{startHour:20,endHour:22,weekday:time.Tuesday,wholeDayOn:false},
-		{startHour:20,endHour:6,weekday:time.Wednesday,wholeDayOn:false},
-		{startHour:10,endHour:18,weekday:time.Thursday,wholeDayOn:false},
-		{startHour:20,endHour:6,weekday:time.Friday,wholeDayOn:false},
-		//now weekends auto-scaling all on since why not
-		{startHour:0,endHour:0,weekday:time.Saturday,wholeDayOn:true},
-		{startHour:0,endHour:0,weekday:time.Sunday,wholeDayOn:true},
-	}
-	var autoscalingBool bool
-	// Test valid pass midnight time , should be true
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,17,0, 0,0,0,time.UTC))
-	assert.Assert(t,autoscalingBool)
-	// Test valid turn on time at night , should be true
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,17,20, 0,0,0,time.UTC))
-	assert.Assert(t,autoscalingBool)
-	// Test valid turn on time between start and end, should be true
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,18,20, 1,0,0,time.UTC))
-	assert.Assert(t,autoscalingBool)
-	// Test turn off time between end and start, should be false
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,19,12, 0,0,0,time.UTC))
-	assert.Assert(t,!autoscalingBool)
-	// Test turn off time before start, should be false
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,20,8, 0,0,0,time.UTC))
-	assert.Assert(t,!autoscalingBool)
-	// Test turn off time after end, should be false
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,20,21, 0,0,0,time.UTC))
-	assert.Assert(t,!autoscalingBool)
-	// Test all true time , should be true
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,22,0, 0,0,0,time.UTC))
-	assert.Assert(t,autoscalingBool)
-	autoscalingBool = AutoScaleByTime(&calenderList,time.Date(2019,time.June,23,0, 0,0,0,time.UTC))
-	assert.Assert(t,autoscalingBool)
-}
-
 func TestFindUnusedNodes(t *testing.T) {
 	//p := fmt.Println
 	podlists := []apiv1.Pod{
@@ -98,11 +60,8 @@ func TestFindUnusedNodes(t *testing.T) {
 		"",
 	}
 	unused := FindUnusedNodes(podlists,nodelists)
-	assert.Assert(t,len(unused) == 4)
-	assert.Assert(t,unused[0]=="11.323.121.455")
-	assert.Assert(t,unused[1]=="23.532.353.245")
-	assert.Assert(t,unused[2]=="")
-	assert.Assert(t,unused[3]=="")
+	// the nodes being provisioned have no IP and can't be removed
+	assert.DeepEqual(t,unused,[]string{"11.323.121.455","23.532.353.245"})
 
 
 	podlists = []apiv1.Pod{
@@ -119,10 +78,7 @@ func TestFindUnusedNodes(t *testing.T) {
 		"23.532.353.245",
 	}
 	unused = FindUnusedNodes(podlists,nodelists)
-	assert.Assert(t,len(unused) == 3)
-	assert.Assert(t,unused[0]=="32.345.678.910")
-	assert.Assert(t,unused[0]=="11.323.121.455")
-	assert.Assert(t,unused[0]=="23.532.353.245")
+	assert.DeepEqual(t,unused,[]string{"32.345.678.910","11.323.121.455","23.532.353.245"})
 }
 
 func TestFindPendingNodes(t *testing.T) {
@@ -205,6 +161,43 @@ func TestSparkAlgoV1(t *testing.T) {
 	assert.Assert(t,!scaleDown && !scaleUp)
 }
 
+func TestSparkAlgoV2(t *testing.T) {
+	// Test invalid inputs: minNode > maxNode, unusedNodes > totalNodes, min + extra > max
+	_,err := SparkAlgoV2(0,0,0,0,5,4,0)
+	assert.Assert(t,err != nil)
+	_,err = SparkAlgoV2(1,2,0,0,2,4,0)
+	assert.Assert(t,err != nil)
+	_,err = SparkAlgoV2(1,0,0,3,3,5,0)
+	assert.Assert(t,err != nil)
+	// Number of Nodes < min + extra, should grow to min + extra at once
+	targetSize,err := SparkAlgoV2(0,0,0,1,2,9,0)
+	assert.NilError(t,err)
+	assert.Equal(t,targetSize,3)
+	// 5 busy nodes, 6 pending pods and 1 extra node, limited by max
+	targetSize,err = SparkAlgoV2(5,0,6,1,2,9,0)
+	assert.NilError(t,err)
+	assert.Equal(t,targetSize,9)
+	// the pending pods take the unused nodes first
+	targetSize,err = SparkAlgoV2(5,2,3,1,2,9,0)
+	assert.NilError(t,err)
+	assert.Equal(t,targetSize,7)
+	// limited by the step
+	targetSize,err = SparkAlgoV2(5,0,6,1,2,9,2)
+	assert.NilError(t,err)
+	assert.Equal(t,targetSize,7)
+	// 4 unused nodes while 1 extra node is needed, drop 3 nodes or the step
+	targetSize,err = SparkAlgoV2(8,4,0,1,2,9,0)
+	assert.NilError(t,err)
+	assert.Equal(t,targetSize,5)
+	targetSize,err = SparkAlgoV2(8,4,0,1,2,9,1)
+	assert.NilError(t,err)
+	assert.Equal(t,targetSize,7)
+	// never below min + extra
+	targetSize,err = SparkAlgoV2(4,4,0,1,2,9,0)
+	assert.NilError(t,err)
+	assert.Equal(t,targetSize,3)
+}
+
 //func TestRefreshToken(t *testing.T) {
 //	scheduler:= Scheduler{
 //		clusterClient:&IBMCloudClient{
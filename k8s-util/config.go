@@ -0,0 +1,150 @@
+package k8s_util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"time"
+)
+
+/*
+Load the configuration of an autoscaler into config, which must be a pointer to a struct holding the default values.
+
+The file at path is read first, it can be either YAML or JSON and its keys are the json tags of the struct fields.
+Then every field with an `env` tag is overridden by the environment variable named by the tag, unset or empty
+environment variables are ignored. An empty path only reads the environment variables.
+Supported field types are string, bool, int, float64 and time.Duration (e.g. "90s"), nested structs are
+walked recursively.
+ */
+func LoadConfig(path string, config interface{}) error {
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("can't read config file %s: %v", path, err)
+		}
+		if err := yaml.UnmarshalStrict(content, config); err != nil {
+			return fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+	}
+	return ApplyEnvOverrides(config)
+}
+
+/*
+Override the fields of config with the environment variables named by their `env` tags
+ */
+func ApplyEnvOverrides(config interface{}) error {
+	value := reflect.ValueOf(config)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", config)
+	}
+	return applyEnvOverrides(value.Elem())
+}
+
+func applyEnvOverrides(value reflect.Value) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		fieldType := value.Type().Field(i)
+		if fieldType.PkgPath != "" {
+			// unexported field
+			continue
+		}
+		if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Duration(0)) {
+			if err := applyEnvOverrides(field); err != nil {
+				return err
+			}
+			continue
+		}
+		envName := fieldType.Tag.Get("env")
+		if envName == "" {
+			continue
+		}
+		envValue := os.Getenv(envName)
+		if envValue == "" {
+			continue
+		}
+		if err := setField(field, envValue); err != nil {
+			return fmt.Errorf("invalid value %q of environment variable %s: %v", envValue, envName, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Ptr {
+		// a pointer tells an unset value from a zero value, it points to the parsed value
+		parsed := reflect.New(field.Type().Elem())
+		if err := setField(parsed.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(parsed)
+		return nil
+	}
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+/*
+Collect the problems found while validating a configuration, so all of them are reported at once
+ */
+type ConfigErrors []string
+
+func (configErrors *ConfigErrors) Add(format string, args ...interface{}) {
+	*configErrors = append(*configErrors, fmt.Sprintf(format, args...))
+}
+
+/*
+Return nil if there is no problem, otherwise an error listing all the problems
+ */
+func (configErrors ConfigErrors) Err() error {
+	if len(configErrors) == 0 {
+		return nil
+	}
+	message := "invalid configuration:"
+	for _, configError := range configErrors {
+		message += "\n  - " + configError
+	}
+	return fmt.Errorf("%s", message)
+}
+
+/*
+Configuration of the leader election among the replicas of an autoscaler
+ */
+type LeaderElectionConfig struct {
+	Enabled   bool   `json:"enabled" env:"LEADER_ELECTION"`
+	Namespace string `json:"namespace" env:"LEADER_ELECTION_NAMESPACE"` //namespace of the Lease
+	LockName  string `json:"lockName" env:"LEADER_ELECTION_LOCK_NAME"`  //name of the Lease
+}
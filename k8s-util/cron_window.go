@@ -0,0 +1,46 @@
+package k8s_util
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"strings"
+	"time"
+)
+
+/*
+A recurring period of time, written as a standard 5-field cron expression for its start followed by
+"for" and its duration, e.g. "0 20 * * 1-5 for 10h" starts at 8pm from Monday to Friday and lasts 10 hours,
+so it ends at 6am the next day. Descriptors such as "@daily" are accepted too.
+ */
+type CronWindow struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+/*
+Parse a cron window from "<cron expression> for <duration>"
+ */
+func ParseCronWindow(spec string) (CronWindow, error) {
+	parts := strings.Split(spec, " for ")
+	if len(parts) != 2 {
+		return CronWindow{}, fmt.Errorf("invalid schedule %q, expected \"<cron expression> for <duration>\"", spec)
+	}
+	schedule, err := cron.ParseStandard(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return CronWindow{}, fmt.Errorf("invalid cron expression in schedule %q: %v", spec, err)
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || duration <= 0 {
+		return CronWindow{}, fmt.Errorf("invalid duration in schedule %q, expected e.g. \"10h\" or \"90m\"", spec)
+	}
+	return CronWindow{schedule: schedule, duration: duration}, nil
+}
+
+/*
+Return true if the window started at most its duration before timeNow, the cron expression is evaluated
+in the location of timeNow
+ */
+func (window CronWindow) Active(timeNow time.Time) bool {
+	start := window.schedule.Next(timeNow.Add(-window.duration))
+	return !start.IsZero() && !start.After(timeNow)
+}
@@ -0,0 +1,39 @@
+package k8s_util
+
+import (
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+func TestCronWindowActive(t *testing.T) {
+	window, err := ParseCronWindow("0 20 * * 1-5 for 10h")
+	assert.NilError(t, err)
+	// Monday 2019-06-17
+	assert.Assert(t, !window.Active(time.Date(2019, time.June, 17, 19, 59, 0, 0, time.UTC)))
+	assert.Assert(t, window.Active(time.Date(2019, time.June, 17, 20, 0, 0, 0, time.UTC)))
+	// the window goes on past midnight
+	assert.Assert(t, window.Active(time.Date(2019, time.June, 18, 5, 59, 0, 0, time.UTC)))
+	assert.Assert(t, !window.Active(time.Date(2019, time.June, 18, 6, 0, 0, 0, time.UTC)))
+	// Friday night goes on to Saturday morning, but Saturday night doesn't start a window
+	assert.Assert(t, window.Active(time.Date(2019, time.June, 22, 1, 0, 0, 0, time.UTC)))
+	assert.Assert(t, !window.Active(time.Date(2019, time.June, 22, 21, 0, 0, 0, time.UTC)))
+	// Monday morning is off, the window of Sunday night doesn't exist
+	assert.Assert(t, !window.Active(time.Date(2019, time.June, 17, 1, 0, 0, 0, time.UTC)))
+}
+
+func TestCronWindowTimeZone(t *testing.T) {
+	window, err := ParseCronWindow("30 8 * * * for 90m")
+	assert.NilError(t, err)
+	location := time.FixedZone("MDT", -6*3600)
+	assert.Assert(t, window.Active(time.Date(2019, time.June, 17, 9, 0, 0, 0, location)))
+	// 9am UTC is 3am in MDT
+	assert.Assert(t, !window.Active(time.Date(2019, time.June, 17, 9, 0, 0, 0, time.UTC).In(location)))
+}
+
+func TestParseCronWindowErrors(t *testing.T) {
+	for _, spec := range []string{"0 20 * * 1-5", "0 20 * * 1-8 for 10h", "0 20 * * * for ten hours", "@daily for 0s"} {
+		_, err := ParseCronWindow(spec)
+		assert.ErrorContains(t, err, spec)
+	}
+}
@@ -1,6 +1,7 @@
 package k8s_util
 
 import (
+	"encoding/json"
 	"fmt"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
@@ -23,7 +24,7 @@ import (
 )
 
 type DeploymentClient struct {
-	Clientset *kubernetes.Clientset
+	Clientset kubernetes.Interface
 	Namespace string
 }
 
@@ -134,6 +135,25 @@ func (deploymentClient *DeploymentClient) DeletePod(podName string){
 	}
 }
 
+/*
+Set the annotation key of a pod to value, an empty value removes the annotation
+ */
+func (deploymentClient *DeploymentClient) AnnotatePod(podName string, key string, value string) error {
+	var annotation interface{}
+	if value != "" {
+		annotation = value
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{key: annotation}},
+	})
+	if err != nil {
+		return err
+	}
+	podsClient := deploymentClient.Clientset.CoreV1().Pods(deploymentClient.Namespace)
+	_, err = podsClient.Patch(podName, types.MergePatchType, patch)
+	return err
+}
+
 /*
 Delete all the pods with same labels defined in input
  */
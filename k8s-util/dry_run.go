@@ -0,0 +1,27 @@
+package k8s_util
+
+/*
+The scale action intended by the rounds of an autoscaler in dry-run mode. Nothing changes in dry-run mode, so the
+same action is intended round after round until the load changes, and it is only counted once as one decision.
+The zero value has no action intended.
+ */
+type DryRunIntent struct {
+	previous string //action intended by the previous round, empty for none
+	current  string //action intended by the current round, empty for none
+}
+
+/*
+Start a new round, the action intended by the current round becomes the previous one
+ */
+func (intent *DryRunIntent) NextRound() {
+	intent.previous, intent.current = intent.current, ""
+}
+
+/*
+Record the action intended by the current round, e.g. "scale_out to 5 nodes", return true if it is a new decision,
+i.e. the previous round didn't intend the same action
+ */
+func (intent *DryRunIntent) Intend(action string) bool {
+	intent.current = action
+	return action != intent.previous
+}
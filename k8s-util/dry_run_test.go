@@ -0,0 +1,21 @@
+package k8s_util
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestDryRunIntent(t *testing.T) {
+	intent := DryRunIntent{}
+	intent.NextRound()
+	assert.Assert(t, intent.Intend("scale_in to 4 nodes"))
+	// the same action intended by the next round is the same decision
+	intent.NextRound()
+	assert.Assert(t, !intent.Intend("scale_in to 4 nodes"))
+	intent.NextRound()
+	assert.Assert(t, intent.Intend("scale_out to 6 nodes"))
+	// a round without action ends the decision
+	intent.NextRound()
+	intent.NextRound()
+	assert.Assert(t, intent.Intend("scale_out to 6 nodes"))
+}
@@ -0,0 +1,115 @@
+package k8s_util
+
+import (
+	"context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+Run the function only while this replica holds the Lease lock, so several replicas of an autoscaler
+can be deployed for availability while only one of them scales the cluster.
+
+Input
+-----
+ctx:		cancel it to stop the function, the lock is released once the function returned
+clientset:	client used to create and renew the Lease
+namespace:	namespace of the Lease
+lockName:	name of the Lease, replicas sharing the same lock name elect one leader
+run:		the function to run as the leader, its context is cancelled when the leadership is lost
+
+The function returns when ctx is cancelled or the leadership is lost. In the latter case the process
+is expected to exit, kubernetes restarts it and it becomes a candidate again.
+ */
+func RunWithLeaderElection(ctx context.Context, clientset kubernetes.Interface, namespace string, lockName string,
+	run func(ctx context.Context)) {
+	identity, err := os.Hostname()
+	if err != nil || identity == "" {
+		log.Fatal("Leader election: can't get the identity of this replica: ", err)
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      lockName,
+			Namespace: namespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+	// running is true while run is being called, finished is set once the leader work is stopped, so a leader
+	// callback which starts late never runs the function
+	var mutex sync.Mutex
+	runStopped := sync.NewCond(&mutex)
+	running, finished := false, false
+	waitRunStopped := func() {
+		mutex.Lock()
+		finished = true
+		for running {
+			runStopped.Wait()
+		}
+		mutex.Unlock()
+	}
+	// the Lease is released when electionCtx is cancelled, which only happens once run returned, so no other
+	// replica can act while this one is still in the middle of an action
+	electionCtx, cancelElection := context.WithCancel(context.Background())
+	defer cancelElection()
+	go func() {
+		select {
+		case <-ctx.Done():
+			waitRunStopped()
+			cancelElection()
+		case <-electionCtx.Done():
+		}
+	}()
+	log.Printf("Leader election: %s is waiting for the lock %s/%s\n", identity, namespace, lockName)
+	leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				mutex.Lock()
+				if finished {
+					mutex.Unlock()
+					return
+				}
+				running = true
+				mutex.Unlock()
+				// the leader work stops on shutdown or when the leadership is lost
+				runCtx, cancelRun := context.WithCancel(leaderCtx)
+				defer cancelRun()
+				go func() {
+					select {
+					case <-ctx.Done():
+						cancelRun()
+					case <-runCtx.Done():
+					}
+				}()
+				log.Printf("Leader election: %s started leading\n", identity)
+				run(runCtx)
+				mutex.Lock()
+				running = false
+				runStopped.Broadcast()
+				mutex.Unlock()
+			},
+			OnStoppedLeading: func() {
+				log.Printf("Leader election: %s stopped leading\n", identity)
+			},
+			OnNewLeader: func(leader string) {
+				log.Printf("Leader election: the leader is %s\n", leader)
+			},
+		},
+	})
+	// the leadership is lost or the Lease is released, wait for the leader to finish its current action before
+	// the process exits
+	waitRunStopped()
+}
@@ -0,0 +1,56 @@
+package k8s_util
+
+import (
+	"context"
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"testing"
+	"time"
+)
+
+func leaseHolder(t *testing.T, clientset *fake.Clientset) string {
+	lease, err := clientset.CoordinationV1().Leases("spark").Get("test-lock", metav1.GetOptions{})
+	assert.NilError(t, err)
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func TestRunWithLeaderElectionKeepsLeaseUntilRunReturns(t *testing.T) {
+	identity, err := os.Hostname()
+	assert.NilError(t, err)
+	clientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started, runCancelled, finish, stopped := make(chan bool), make(chan bool), make(chan bool), make(chan bool)
+	go func() {
+		RunWithLeaderElection(ctx, clientset, "spark", "test-lock", func(runCtx context.Context) {
+			started <- true
+			<-runCtx.Done()
+			runCancelled <- true
+			// an action still going on after the shutdown
+			<-finish
+		})
+		stopped <- true
+	}()
+	<-started
+	assert.Equal(t, leaseHolder(t, clientset), identity)
+
+	cancel()
+	<-runCancelled
+	time.Sleep(100 * time.Millisecond)
+	// the Lease is kept while the action goes on, so no other replica acts at the same time
+	assert.Equal(t, leaseHolder(t, clientset), identity)
+	select {
+	case <-stopped:
+		t.Fatal("returned while run was still running")
+	default:
+	}
+
+	finish <- true
+	<-stopped
+	assert.Equal(t, leaseHolder(t, clientset), "")
+}
@@ -0,0 +1,26 @@
+package k8s_util
+
+import (
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net/http"
+)
+
+/*
+Start an HTTP server in the background which exposes the Prometheus metrics on /metrics,
+an empty address disables the server
+ */
+func ServeMetrics(address string) {
+	if address == "" {
+		log.Println("Metrics endpoint is disabled")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Println("Serving metrics on ", address+"/metrics")
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Println("Metrics endpoint error: ", err)
+		}
+	}()
+}
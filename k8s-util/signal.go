@@ -0,0 +1,44 @@
+package k8s_util
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+/*
+Return a context which is cancelled on SIGTERM or SIGINT, so the autoscaler can stop after the
+current action when kubernetes stops the pod. A second signal exits the process directly.
+ */
+func SetupSignalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Println("Received signal ", sig, ", shutting down")
+		cancel()
+		<-signals
+		log.Println("Received second signal, exit directly")
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+/*
+Sleep for the duration unless the context is cancelled in the meantime,
+return false if the context is cancelled
+ */
+func SleepWithContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,145 @@
+package k8s_util
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+/*
+Policies choosing the nodes or the workers removed when scaling in
+ */
+const (
+	VictimPolicyRandom            = "random"              //any candidate
+	VictimPolicyOldest            = "oldest"              //the oldest candidates first, to rotate stale nodes
+	VictimPolicyNewest            = "newest"              //the newest candidates first
+	VictimPolicyLeastRecentlyUsed = "least-recently-used" //the candidates idle for the longest time first
+	VictimPolicyZoneBalanced      = "zone-balanced"       //the candidates of the zones with the most nodes or workers first
+	VictimPolicyProvisioningFirst = "provisioning-first"  //the candidates still being provisioned first
+)
+
+var VictimPolicies = []string{VictimPolicyRandom, VictimPolicyOldest, VictimPolicyNewest,
+	VictimPolicyLeastRecentlyUsed, VictimPolicyZoneBalanced, VictimPolicyProvisioningFirst}
+
+/*
+Return true if policy is one of VictimPolicies
+ */
+func IsVictimPolicy(policy string) bool {
+	for _, victimPolicy := range VictimPolicies {
+		if policy == victimPolicy {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+A node or a worker which can be removed
+ */
+type VictimCandidate struct {
+	Name         string    //name given back by SelectVictims, e.g. the IP of a node or the name of a pod
+	Created      time.Time //creation time of the node or the pod
+	IdleSince    time.Time //since when the candidate has been idle, zero if unknown
+	Zone         string    //zone of the node, empty if unknown
+	Provisioning bool      //the candidate is still being provisioned and isn't used yet
+}
+
+/*
+Return the names of up to count candidates to remove in the order of policy, the candidates which policy can't
+tell apart are taken in a random order, an unknown policy is random.
+
+Input
+-----
+policy: one of VictimPolicies
+candidates: the nodes or workers which can be removed
+zoneSizes: the number of nodes or workers in every zone, including the ones which can't be removed,
+only used by the zone-balanced policy
+count: the number of candidates to remove
+
+Output
+------
+the names of the candidates to remove
+ */
+func SelectVictims(policy string, candidates []VictimCandidate, zoneSizes map[string]int, count int) []string {
+	return victimNames(orderVictims(policy, candidates, zoneSizes), count)
+}
+
+/*
+Return the names of up to count candidates to remove while keeping the zones balanced: every candidate comes from
+the zone with the most nodes or workers left once the previous ones are removed, and the candidates of a zone are
+taken in the order of policy. zoneSizes is the number of nodes or workers in every zone.
+ */
+func SelectZoneBalancedVictims(policy string, candidates []VictimCandidate, zoneSizes map[string]int, count int) []string {
+	return victimNames(zoneBalancedOrder(orderVictims(policy, candidates, zoneSizes), zoneSizes), count)
+}
+
+func orderVictims(policy string, candidates []VictimCandidate, zoneSizes map[string]int) []VictimCandidate {
+	ordered := append([]VictimCandidate{}, candidates...)
+	rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	switch policy {
+	case VictimPolicyOldest:
+		sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Created.Before(ordered[j].Created) })
+	case VictimPolicyNewest:
+		sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Created.After(ordered[j].Created) })
+	case VictimPolicyLeastRecentlyUsed:
+		// the candidates without idle time go last
+		sort.SliceStable(ordered, func(i, j int) bool {
+			if ordered[i].IdleSince.IsZero() || ordered[j].IdleSince.IsZero() {
+				return !ordered[i].IdleSince.IsZero() && ordered[j].IdleSince.IsZero()
+			}
+			return ordered[i].IdleSince.Before(ordered[j].IdleSince)
+		})
+	case VictimPolicyProvisioningFirst:
+		sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Provisioning && !ordered[j].Provisioning })
+	case VictimPolicyZoneBalanced:
+		ordered = zoneBalancedOrder(ordered, zoneSizes)
+	}
+	return ordered
+}
+
+func victimNames(ordered []VictimCandidate, count int) []string {
+	names := []string{}
+	for _, candidate := range ordered {
+		if len(names) >= count {
+			break
+		}
+		names = append(names, candidate.Name)
+	}
+	return names
+}
+
+/*
+Order the candidates so every one comes from the zone with the most nodes or workers left once the previous ones
+are removed, which keeps the zones balanced, the candidates of zones of the same size keep their order
+ */
+func zoneBalancedOrder(candidates []VictimCandidate, zoneSizes map[string]int) []VictimCandidate {
+	sizes := map[string]int{}
+	for zone, size := range zoneSizes {
+		sizes[zone] = size
+	}
+	left := append([]VictimCandidate{}, candidates...)
+	ordered := []VictimCandidate{}
+	for len(left) > 0 {
+		largest := 0
+		for i := range left {
+			if sizes[left[i].Zone] > sizes[left[largest].Zone] {
+				largest = i
+			}
+		}
+		sizes[left[largest].Zone]--
+		ordered = append(ordered, left[largest])
+		left = append(left[:largest], left[largest+1:]...)
+	}
+	return ordered
+}
+
+/*
+Return the zone of a node from its topology labels, empty if it has none
+ */
+func NodeZone(node *apiv1.Node) string {
+	if zone, exist := node.Labels["topology.kubernetes.io/zone"]; exist {
+		return zone
+	}
+	return node.Labels[apiv1.LabelZoneFailureDomain]
+}
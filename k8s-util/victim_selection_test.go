@@ -0,0 +1,64 @@
+package k8s_util
+
+import (
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"testing"
+	"time"
+)
+
+var victimTime = time.Date(2019, time.June, 17, 12, 0, 0, 0, time.UTC)
+
+var victimCandidates = []VictimCandidate{
+	{Name: "b", Created: victimTime.Add(-2 * time.Hour), IdleSince: victimTime.Add(-10 * time.Minute), Zone: "tor01"},
+	{Name: "c", Created: victimTime.Add(-1 * time.Hour), Zone: "tor02", Provisioning: true},
+	{Name: "a", Created: victimTime.Add(-3 * time.Hour), IdleSince: victimTime.Add(-5 * time.Minute), Zone: "tor01"},
+	{Name: "d", Created: victimTime, IdleSince: victimTime.Add(-20 * time.Minute), Zone: "tor03"},
+}
+
+func TestSelectVictims(t *testing.T) {
+	assert.DeepEqual(t, SelectVictims(VictimPolicyOldest, victimCandidates, nil, 4), []string{"a", "b", "c", "d"})
+	assert.DeepEqual(t, SelectVictims(VictimPolicyNewest, victimCandidates, nil, 2), []string{"d", "c"})
+	// the candidate without idle time goes last
+	assert.DeepEqual(t, SelectVictims(VictimPolicyLeastRecentlyUsed, victimCandidates, nil, 4), []string{"d", "b", "a", "c"})
+	assert.DeepEqual(t, SelectVictims(VictimPolicyProvisioningFirst, victimCandidates, nil, 1), []string{"c"})
+	random := SelectVictims(VictimPolicyRandom, victimCandidates, nil, 4)
+	sort.Strings(random)
+	assert.DeepEqual(t, random, []string{"a", "b", "c", "d"})
+	assert.DeepEqual(t, SelectVictims(VictimPolicyOldest, victimCandidates, nil, 0), []string{})
+}
+
+func TestSelectVictimsZoneBalanced(t *testing.T) {
+	zoneSizes := map[string]int{"tor01": 5, "tor02": 3, "tor03": 1}
+	// tor01 goes down to 3 nodes, then only tor02 and tor03 have candidates left
+	victims := SelectVictims(VictimPolicyZoneBalanced, victimCandidates, zoneSizes, 3)
+	sort.Strings(victims[:2])
+	assert.DeepEqual(t, victims, []string{"a", "b", "c"})
+	zoneSizes = map[string]int{"tor01": 2, "tor02": 1, "tor03": 3}
+	assert.DeepEqual(t, SelectVictims(VictimPolicyZoneBalanced, victimCandidates, zoneSizes, 1), []string{"d"})
+}
+
+func TestIsVictimPolicy(t *testing.T) {
+	assert.Assert(t, IsVictimPolicy("least-recently-used"))
+	assert.Assert(t, !IsVictimPolicy("lru"))
+}
+
+func TestNodeZone(t *testing.T) {
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{apiv1.LabelZoneFailureDomain: "tor01"}}}
+	assert.Equal(t, NodeZone(node), "tor01")
+	node.Labels["topology.kubernetes.io/zone"] = "tor02"
+	assert.Equal(t, NodeZone(node), "tor02")
+	assert.Equal(t, NodeZone(&apiv1.Node{}), "")
+}
+
+func TestSelectZoneBalancedVictims(t *testing.T) {
+	zoneSizes := map[string]int{"tor01": 3, "tor02": 3, "tor03": 1}
+	// the oldest candidate of tor01 and tor02 first, then the other tor01 candidate since tor01 and tor02 have
+	// 2 nodes left, d stays in the smallest zone
+	assert.DeepEqual(t, SelectZoneBalancedVictims(VictimPolicyOldest, victimCandidates, zoneSizes, 4),
+		[]string{"a", "c", "b", "d"})
+	zoneSizes = map[string]int{"tor01": 2, "tor02": 3, "tor03": 1}
+	assert.DeepEqual(t, SelectZoneBalancedVictims(VictimPolicyNewest, victimCandidates, zoneSizes, 2), []string{"c", "b"})
+}
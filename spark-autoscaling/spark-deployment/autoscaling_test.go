@@ -2,26 +2,36 @@ package spark_deployment
 
 import (
 	"bytes"
+	"context"
 	"github.com/google/uuid"
 	"github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"log"
 	"math/rand"
+	"os"
 	"strings"
 	"testing"
 	"time"
 )
 
 func TestAutoScaling(t *testing.T)  {
-	cluster:= NewSparkCluster(false)
+	if testing.Short() {
+		t.Skip("skipping test against a live kubernetes cluster in short mode")
+	}
+	config,err:=LoadSparkClusterConfig(os.Getenv("CONFIG_FILE"))
+	if err!=nil{
+		t.Fatal(err)
+	}
+	config.InCluster=false
+	cluster:= NewSparkCluster(config)
 	sparkMockClients:=NewSparkMockClients(cluster.sparkWorkerDeployment.deploymentClient,
 		"zebinkang/cluster-autoscaling-test:0.1",
 		cluster.sparkWorkerDeployment.sparkService,
 		cluster.sparkWorkerDeployment.deploymentResource.Cores)
 	sparkMockClients.deploymentClient.DeletePodWithLabel(sparkMockClients.labels)
 	cleanExstingSparkCluster:=false
-	go cluster.Deploy(cleanExstingSparkCluster)
+	go cluster.Deploy(context.Background(),cleanExstingSparkCluster)
 	go periodCheckClientStatus(sparkMockClients)
 	time.Sleep(15000 * time.Millisecond)
 
@@ -38,7 +48,7 @@ func TestAutoScaling(t *testing.T)  {
 func randomAddOrDelete(sparkMockClients *SparkMockClients)  {
 	randomInt:=(time.Now().Nanosecond()/1000)%2
 
-	pods:=sparkMockClients.deploymentClient.GetPodListWithLabels(sparkMockClients.labels)
+	pods,_:=sparkMockClients.deploymentClient.GetPodListWithLabels(sparkMockClients.labels)
 	if randomInt==0 && len(pods)>0{
 		log.Println("Rmove conn")
 		sparkMockClients.deleteRandomSparkConn()
@@ -50,7 +60,7 @@ func randomAddOrDelete(sparkMockClients *SparkMockClients)  {
 
 func periodCheckClientStatus(sparkMockClients *SparkMockClients)   {
 	for {
-		pods:=sparkMockClients.deploymentClient.GetPodListWithLabels(sparkMockClients.labels)
+		pods,_:=sparkMockClients.deploymentClient.GetPodListWithLabels(sparkMockClients.labels)
 		for _,pod:=range pods{
 			if pod.Status.Phase=="Running" && pod.Status.ContainerStatuses[0].Ready {
 				sparkMockClients.checkMockClientStatus(pod.Name)
@@ -165,7 +175,7 @@ func (sparkMockClients SparkMockClients) generateWorkerConfig() (*apiv1.Pod) {
 
 			},
 			ImagePullSecrets: []apiv1.LocalObjectReference{
-				{"image-pull-secret-ibm-cloud"},
+				{Name: "image-pull-secret-ibm-cloud"},
 			},
 		},
 	}
@@ -178,7 +188,7 @@ func (sparkMockClients SparkMockClients) addWMockClient() {
 
 
 func (sparkMockClients SparkMockClients) deleteRandomSparkConn() {
-	pods:=sparkMockClients.deploymentClient.GetPodListWithLabels(sparkMockClients.labels)
+	pods,_:=sparkMockClients.deploymentClient.GetPodListWithLabels(sparkMockClients.labels)
 	if len(pods)>0{
 		i := rand.Intn(len(pods))
 		sparkMockClients.deploymentClient.DeletePod(pods[i].Name)
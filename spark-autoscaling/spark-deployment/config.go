@@ -0,0 +1,189 @@
+package spark_deployment
+
+import (
+	"github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+Configuration of the Spark master deployment
+ */
+type SparkMasterConfig struct {
+	Image        string `json:"image" env:"SPARK_MASTER_IMAGE"`
+	Pool         string `json:"pool" env:"SPARK_MASTER_POOL"` //worker pool the master is scheduled to
+	Cores        string `json:"cores" env:"SPARK_MASTER_CORES"`
+	Mem          string `json:"mem" env:"SPARK_MASTER_MEM"`
+	ContainerCpu string `json:"containerCpu" env:"SPARK_MASTER_CONTAINER_CPU"`
+	ContainerMem string `json:"containerMem" env:"SPARK_MASTER_CONTAINER_MEM"`
+}
+
+/**
+Configuration of the Spark worker pods
+ */
+type SparkWorkerConfig struct {
+	Image        string `json:"image" env:"SPARK_WORKER_IMAGE"`
+	Pool         string `json:"pool" env:"SPARK_WORKER_POOL"` //worker pool the workers are scheduled to
+	Opts         string `json:"opts" env:"SPARK_WORKER_OPTS"`
+	Cores        string `json:"cores" env:"SPARK_WORKER_CORES"` //cores of one worker, a whole number
+	Mem          string `json:"mem" env:"SPARK_WORKER_MEM"`
+	ContainerCpu string `json:"containerCpu" env:"SPARK_WORKER_CONTAINER_CPU"`
+	ContainerMem string `json:"containerMem" env:"SPARK_WORKER_CONTAINER_MEM"`
+}
+
+/**
+Configuration of the Spark worker autoscaler, loaded from the file in CONFIG_FILE, any field can be overridden
+by the environment variable in its env tag
+ */
+type SparkClusterConfig struct {
+	InCluster                 bool                          `json:"inCluster" env:"IS_IN_CLUSTER"`
+	CleanExistingDeployment   bool                          `json:"cleanExistingDeployment" env:"CLEAN_EXISTING_DEPLOYMENT"` //redeploy the master and all workers at start
+	Namespace                 string                        `json:"namespace" env:"SPARK_CLUSTER_NAMESPACE"`
+	ClusterInfoURL            string                        `json:"clusterInfoUrl" env:"SPARK_CLUSTER_INFO_URL"`                 //json endpoint of the Spark master web UI
+	ClusterInfoTimeout        string                        `json:"clusterInfoTimeout" env:"SPARK_CLUSTER_INFO_TIMEOUT"`         //e.g. "5s", timeout of one request to the Spark master
+	ClusterInfoRetries        int                           `json:"clusterInfoRetries" env:"SPARK_CLUSTER_INFO_RETRIES"`         //retries of a failed request to the Spark master
+	ExtraSparkWorker          int                           `json:"extraSparkWorker" env:"EXTRA_SPARK_WORKER"`                   //extra idle workers for additional usage
+	MaxScaleStep              int                           `json:"maxScaleStep" env:"MAX_SCALE_STEP"`                           //most workers added or removed in one cycle
+	ScaleInPolicy             string                        `json:"scaleInPolicy" env:"SCALE_IN_POLICY"`                         //policy choosing the idle workers to remove, e.g. "least-recently-used"
+	WorkerDecommission        string                        `json:"workerDecommission" env:"WORKER_DECOMMISSION"`                //"none" or "master", how a worker is released before its pod is deleted
+	WorkerDecommissionTimeout string                        `json:"workerDecommissionTimeout" env:"WORKER_DECOMMISSION_TIMEOUT"` //e.g. "10m", wait for the executors of a decommissioned worker
+	ExtraSparkWorkerSchedule  []ExtraSparkWorkerWindow      `json:"extraSparkWorkerSchedule,omitempty"`                          //extra idle workers by time, ExtraSparkWorker when no window is active
+	TimeZone                  string                        `json:"timeZone" env:"TIME_ZONE"`                                    //time zone of the schedule
+	DryRun                    bool                          `json:"dryRun" env:"DRY_RUN"`                                        //only log the pods which would be created or deleted
+	MetricsAddress            string                        `json:"metricsAddress" env:"METRICS_ADDRESS"`                        //empty to disable the metrics endpoint
+	LeaderElection            k8s_util.LeaderElectionConfig `json:"leaderElection"`
+	Behavior                  ScalingBehaviorConfig         `json:"behavior"`
+	Master                    SparkMasterConfig             `json:"master"`
+	Worker                    SparkWorkerConfig             `json:"worker"`
+}
+
+/**
+This function returns the configuration with its default values
+ */
+func DefaultSparkClusterConfig() SparkClusterConfig {
+	return SparkClusterConfig{
+		InCluster:                 true,
+		ClusterInfoTimeout:        "5s",
+		ClusterInfoRetries:        2,
+		MaxScaleStep:              1,
+		ScaleInPolicy:             k8s_util.VictimPolicyRandom,
+		WorkerDecommission:        "none",
+		WorkerDecommissionTimeout: "10m",
+		Behavior: ScalingBehaviorConfig{
+			WorkerIdleTime:               "0s",
+			ScaleUpStabilizationWindow:   "0s",
+			ScaleDownStabilizationWindow: "0s",
+			ScaleOutCooldown:             "0s",
+			ScaleInCooldown:              "0s",
+		},
+		MetricsAddress: ":9090",
+		TimeZone:       "UTC",
+	}
+}
+
+/**
+This function loads the configuration from the file at path and the environment variables, then validates it
+ */
+func LoadSparkClusterConfig(path string) (SparkClusterConfig, error) {
+	config := DefaultSparkClusterConfig()
+	if err := k8s_util.LoadConfig(path, &config); err != nil {
+		return config, err
+	}
+	if config.LeaderElection.Namespace == "" {
+		config.LeaderElection.Namespace = config.Namespace
+	}
+	if config.LeaderElection.LockName == "" {
+		config.LeaderElection.LockName = "spark-custom-autoscaler"
+	}
+	return config, config.Validate()
+}
+
+/**
+This function returns an error listing all the invalid values of the configuration
+ */
+func (config SparkClusterConfig) Validate() error {
+	configErrors := k8s_util.ConfigErrors{}
+	if config.Namespace == "" {
+		configErrors.Add("namespace (SPARK_CLUSTER_NAMESPACE) is required")
+	}
+	if config.ClusterInfoURL == "" {
+		configErrors.Add("clusterInfoUrl (SPARK_CLUSTER_INFO_URL) is required")
+	}
+	if timeout, err := time.ParseDuration(config.ClusterInfoTimeout); err != nil || timeout <= 0 {
+		configErrors.Add("clusterInfoTimeout (SPARK_CLUSTER_INFO_TIMEOUT) must be a positive duration like \"5s\", got %q",
+			config.ClusterInfoTimeout)
+	}
+	if config.ClusterInfoRetries < 0 {
+		configErrors.Add("clusterInfoRetries (SPARK_CLUSTER_INFO_RETRIES) can't be negative")
+	}
+	if config.ExtraSparkWorker < 0 {
+		configErrors.Add("extraSparkWorker (EXTRA_SPARK_WORKER) can't be negative")
+	}
+	if config.MaxScaleStep < 1 {
+		configErrors.Add("maxScaleStep (MAX_SCALE_STEP) must be at least 1")
+	}
+	if !k8s_util.IsVictimPolicy(config.ScaleInPolicy) {
+		configErrors.Add("scaleInPolicy (SCALE_IN_POLICY) must be one of %s, got %q",
+			strings.Join(k8s_util.VictimPolicies, ", "), config.ScaleInPolicy)
+	}
+	if config.WorkerDecommission != "none" && config.WorkerDecommission != "master" {
+		configErrors.Add("workerDecommission (WORKER_DECOMMISSION) must be \"none\" or \"master\", got %q",
+			config.WorkerDecommission)
+	}
+	if timeout, err := time.ParseDuration(config.WorkerDecommissionTimeout); err != nil || timeout < 0 {
+		configErrors.Add("workerDecommissionTimeout (WORKER_DECOMMISSION_TIMEOUT) must be a duration like \"10m\", got %q",
+			config.WorkerDecommissionTimeout)
+	}
+	validateBehaviorDuration(&configErrors, "behavior.workerIdleTime (WORKER_IDLE_TIME)", config.Behavior.WorkerIdleTime)
+	validateBehaviorDuration(&configErrors, "behavior.scaleUpStabilizationWindow (SCALE_UP_STABILIZATION_WINDOW)",
+		config.Behavior.ScaleUpStabilizationWindow)
+	validateBehaviorDuration(&configErrors, "behavior.scaleDownStabilizationWindow (SCALE_DOWN_STABILIZATION_WINDOW)",
+		config.Behavior.ScaleDownStabilizationWindow)
+	validateBehaviorDuration(&configErrors, "behavior.scaleOutCooldown (SCALE_OUT_COOLDOWN)", config.Behavior.ScaleOutCooldown)
+	validateBehaviorDuration(&configErrors, "behavior.scaleInCooldown (SCALE_IN_COOLDOWN)", config.Behavior.ScaleInCooldown)
+	for i, window := range config.ExtraSparkWorkerSchedule {
+		if _, err := k8s_util.ParseCronWindow(window.Schedule); err != nil {
+			configErrors.Add("extraSparkWorkerSchedule[%d]: %v", i, err)
+		}
+		if window.ExtraSparkWorker < 0 {
+			configErrors.Add("extraSparkWorkerSchedule[%d]: extraSparkWorker can't be negative", i)
+		}
+	}
+	if _, err := time.LoadLocation(config.TimeZone); err != nil {
+		configErrors.Add("invalid timeZone (TIME_ZONE) %q: %v", config.TimeZone, err)
+	}
+	if config.Master.Image == "" {
+		configErrors.Add("master.image (SPARK_MASTER_IMAGE) is required")
+	}
+	if config.Worker.Image == "" {
+		configErrors.Add("worker.image (SPARK_WORKER_IMAGE) is required")
+	}
+	if cores, err := strconv.Atoi(config.Worker.Cores); err != nil || cores < 1 {
+		configErrors.Add("worker.cores (SPARK_WORKER_CORES) must be a whole number of cores, got %q", config.Worker.Cores)
+	}
+	if _, err := parseSparkMemoryMB(config.Worker.Mem); err != nil {
+		configErrors.Add("worker.mem (SPARK_WORKER_MEM): %v", err)
+	}
+	validateQuantity(&configErrors, "master.containerCpu (SPARK_MASTER_CONTAINER_CPU)", config.Master.ContainerCpu)
+	validateQuantity(&configErrors, "master.containerMem (SPARK_MASTER_CONTAINER_MEM)", config.Master.ContainerMem)
+	validateQuantity(&configErrors, "worker.containerCpu (SPARK_WORKER_CONTAINER_CPU)", config.Worker.ContainerCpu)
+	validateQuantity(&configErrors, "worker.containerMem (SPARK_WORKER_CONTAINER_MEM)", config.Worker.ContainerMem)
+	return configErrors.Err()
+}
+
+func validateBehaviorDuration(configErrors *k8s_util.ConfigErrors, name string, value string) {
+	if duration, err := time.ParseDuration(value); err != nil || duration < 0 {
+		configErrors.Add("%s must be a duration like \"5m\" or \"0s\", got %q", name, value)
+	}
+}
+
+/**
+The container resources are parsed as kubernetes quantities when the pods are created, check them early
+ */
+func validateQuantity(configErrors *k8s_util.ConfigErrors, name string, value string) {
+	if _, err := resource.ParseQuantity(value); err != nil {
+		configErrors.Add("%s must be a kubernetes quantity like \"2\" or \"4Gi\", got %q", name, value)
+	}
+}
@@ -0,0 +1,77 @@
+package spark_deployment
+
+import (
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const validSparkClusterConfig = `
+namespace: spark
+clusterInfoUrl: http://spark-webui.spark:8080/json
+extraSparkWorker: 1
+master:
+  image: spark:2.2.3
+  pool: spark-master
+  cores: "1"
+  mem: 2g
+  containerCpu: "0.1"
+  containerMem: 2Gi
+worker:
+  image: spark:2.2.3
+  pool: spark-worker
+  cores: "2"
+  mem: 2g
+  containerCpu: "0.1"
+  containerMem: 2Gi
+`
+
+func loadSparkClusterConfig(t *testing.T, content string) (SparkClusterConfig, error) {
+	file, err := ioutil.TempFile("", "spark-config-*.yaml")
+	assert.NilError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(content)
+	assert.NilError(t, err)
+	assert.NilError(t, file.Close())
+	return LoadSparkClusterConfig(file.Name())
+}
+
+func TestLoadSparkClusterConfig(t *testing.T) {
+	os.Setenv("EXTRA_SPARK_WORKER", "3")
+	defer os.Unsetenv("EXTRA_SPARK_WORKER")
+	config, err := loadSparkClusterConfig(t, validSparkClusterConfig)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Worker.Cores, "2")
+	assert.Equal(t, config.Master.Pool, "spark-master")
+	// the environment variable overrides the file
+	assert.Equal(t, config.ExtraSparkWorker, 3)
+	assert.Equal(t, config.LeaderElection.Namespace, "spark")
+	assert.Equal(t, config.LeaderElection.LockName, "spark-custom-autoscaler")
+	assert.Equal(t, config.MaxScaleStep, 1)
+	assert.Equal(t, config.WorkerDecommission, "none")
+	assert.Equal(t, config.ScaleInPolicy, "random")
+	assert.Equal(t, config.Behavior.ScaleDownStabilizationWindow, "0s")
+}
+
+func TestLoadSparkClusterConfigValidation(t *testing.T) {
+	content := strings.Replace(validSparkClusterConfig, `cores: "2"`, `cores: "0.5"`, 1)
+	content = strings.Replace(content, "containerMem: 2Gi", "containerMem: 2 GB", 1)
+	content += "maxScaleStep: 0\n"
+	content += "clusterInfoTimeout: 5\n"
+	content += "workerDecommission: signal\n"
+	content += "scaleInPolicy: lru\n"
+	content += "behavior:\n  workerIdleTime: 5\n  scaleInCooldown: -1m\n"
+	content += "extraSparkWorkerSchedule:\n- schedule: \"0 8 * * 1-5\"\n  extraSparkWorker: 2\n"
+	_, err := loadSparkClusterConfig(t, content)
+	assert.ErrorContains(t, err, "worker.cores (SPARK_WORKER_CORES)")
+	assert.ErrorContains(t, err, "master.containerMem (SPARK_MASTER_CONTAINER_MEM)")
+	assert.ErrorContains(t, err, "extraSparkWorkerSchedule[0]")
+	assert.ErrorContains(t, err, "maxScaleStep (MAX_SCALE_STEP)")
+	assert.ErrorContains(t, err, "clusterInfoTimeout (SPARK_CLUSTER_INFO_TIMEOUT)")
+	assert.ErrorContains(t, err, "workerDecommission (WORKER_DECOMMISSION)")
+	assert.ErrorContains(t, err, "scaleInPolicy (SCALE_IN_POLICY)")
+	assert.ErrorContains(t, err, "behavior.workerIdleTime (WORKER_IDLE_TIME)")
+	assert.ErrorContains(t, err, "behavior.scaleInCooldown (SCALE_IN_COOLDOWN)")
+}
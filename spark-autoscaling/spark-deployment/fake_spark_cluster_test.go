@@ -0,0 +1,73 @@
+package spark_deployment
+
+import (
+	"fmt"
+	"github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+/**
+This function creates a SparkCluster backed by a fake Clientset holding the given worker pods, and a fake
+Spark master serving clusterInfo as its json. The caller closes the returned server.
+ */
+func newFakeSparkCluster(pods []apiv1.Pod, clusterInfo string) (*SparkCluster, *fake.Clientset, *httptest.Server) {
+	master := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = fmt.Fprint(writer, clusterInfo)
+	}))
+	clientSet := fake.NewSimpleClientset()
+	for i := range pods {
+		_, _ = clientSet.CoreV1().Pods("spark").Create(&pods[i])
+	}
+	deploymentClient := &k8s_util.DeploymentClient{Clientset: clientSet, Namespace: "spark"}
+	resource := k8s_util.NewDeploymentResource("1", "2g", "0.1", "2Gi")
+	cluster := &SparkCluster{
+		sparkMasterDeployment: NewSparkMasterDeployment(deploymentClient, "spark:2.2.3", "spark-master", resource),
+		sparkWorkerDeployment: NewSparkWorkerDeployment(deploymentClient, "spark:2.2.3", "spark-worker", resource,
+			"", 1, NewSparkMasterClient(master.URL, time.Second, 0), false, time.Minute, "random"),
+		extraSparkWorkerSchedule: newExtraSparkWorkerSchedule(nil, "UTC"),
+		stabilizer:               newScaleStabilizer(ScalingBehaviorConfig{}),
+		idleTracker:              newWorkerIdleTracker(0),
+		dryRunIntent:             &k8s_util.DryRunIntent{},
+	}
+	return cluster, clientSet, master
+}
+
+/**
+This function creates a Spark worker pod in the given phase
+ */
+func newFakeWorkerPod(name string, phase apiv1.PodPhase) apiv1.Pod {
+	return apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "spark",
+			Labels:    map[string]string{"component": "spark-worker", "pool": "spark-worker"},
+		},
+		Status: apiv1.PodStatus{Phase: phase},
+	}
+}
+
+/**
+This function returns the names of the Spark worker pods in the fake Clientset
+ */
+func workerPodNames(clientSet *fake.Clientset) []string {
+	pods, _ := clientSet.CoreV1().Pods("spark").List(metav1.ListOptions{})
+	names := []string{}
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+/**
+This function creates a running Spark worker pod with the given pod IP
+ */
+func newFakeRunningWorkerPod(name string, podIP string) apiv1.Pod {
+	pod := newFakeWorkerPod(name, apiv1.PodRunning)
+	pod.Status.PodIP = podIP
+	return pod
+}
@@ -0,0 +1,108 @@
+package spark_deployment
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+/**
+Prometheus metrics of the Spark worker autoscaler
+ */
+var (
+	coresUsedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_cores_used",
+		Help: "Cores in use reported by the Spark master.",
+	})
+	targetCoresGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_target_cores",
+		Help: "Cores the autoscaler is scaling the Spark workers to.",
+	})
+	memoryUsedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_memory_used_mb",
+		Help: "Memory in use in MB reported by the Spark master.",
+	})
+	waitingCoresGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_waiting_cores",
+		Help: "Cores requested by the WAITING applications and the SUBMITTED drivers reported by the Spark master.",
+	})
+	waitingMemoryGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_waiting_memory_mb",
+		Help: "Memory in MB requested by the WAITING applications and the SUBMITTED drivers reported by the Spark master.",
+	})
+	desiredWorkersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_desired_workers",
+		Help: "Spark workers the autoscaler is scaling to after the stabilization windows.",
+	})
+	targetMemoryGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_target_memory_mb",
+		Help: "Memory in MB the autoscaler is scaling the Spark workers to.",
+	})
+	extraWorkersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_extra_workers",
+		Help: "Extra idle Spark workers the autoscaler keeps at the moment, according to the schedule.",
+	})
+	currentCoresGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_current_cores",
+		Help: "Cores of all the Spark worker pods, including the pending ones.",
+	})
+	workerPodsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_worker_pods",
+		Help: "Number of Spark worker pods by pod phase.",
+	}, []string{"phase"})
+	idleWorkersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spark_autoscaler_idle_alive_workers",
+		Help: "Number of ALIVE Spark workers without any core in use reported by the Spark master.",
+	})
+	scaleOutCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spark_autoscaler_scale_out_total",
+		Help: "Number of Spark workers added by the autoscaler.",
+	})
+	scaleInCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spark_autoscaler_scale_in_total",
+		Help: "Number of Spark workers removed by the autoscaler.",
+	})
+	addWorkerDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "spark_autoscaler_add_worker_duration_seconds",
+		Help:    "Time taken to create a Spark worker pod, the worker registers with the master afterwards.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	removeWorkerDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "spark_autoscaler_remove_worker_duration_seconds",
+		Help:    "Time taken to remove a Spark worker pod.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	dryRunActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spark_autoscaler_dry_run_actions_total",
+		Help: "Worker pods the autoscaler would have created or deleted in dry-run mode, an action intended by consecutive rounds is counted once.",
+	}, []string{"action"})
+)
+
+// values of the action label of the dry-run counter
+const (
+	dryRunActionAddWorker    = "add_worker"
+	dryRunActionRemoveWorker = "remove_worker"
+)
+
+func init() {
+	prometheus.MustRegister(coresUsedGauge, targetCoresGauge, memoryUsedGauge, targetMemoryGauge, waitingCoresGauge, waitingMemoryGauge,
+		desiredWorkersGauge, extraWorkersGauge, currentCoresGauge, workerPodsGauge, idleWorkersGauge, scaleOutCounter, scaleInCounter, addWorkerDuration, removeWorkerDuration, dryRunActions)
+}
+
+/**
+This function updates the worker pod gauge, every phase is reported so a phase without pods goes back to 0
+ */
+func recordWorkerPods(pods []apiv1.Pod) {
+	podsByPhase := map[apiv1.PodPhase]int{
+		apiv1.PodPending:   0,
+		apiv1.PodRunning:   0,
+		apiv1.PodSucceeded: 0,
+		apiv1.PodFailed:    0,
+		apiv1.PodUnknown:   0,
+	}
+	for _, pod := range pods {
+		podsByPhase[pod.Status.Phase]++
+	}
+	for phase, count := range podsByPhase {
+		workerPodsGauge.WithLabelValues(string(phase)).Set(float64(count))
+	}
+}
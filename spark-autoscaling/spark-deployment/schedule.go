@@ -0,0 +1,57 @@
+package spark_deployment
+
+import (
+	"github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	"time"
+)
+
+/**
+Number of extra idle Spark workers while a cron window is active,
+e.g. {schedule: "0 8 * * 1-5 for 10h", extraSparkWorker: 3} during office hours
+ */
+type ExtraSparkWorkerWindow struct {
+	Schedule         string `json:"schedule"`
+	ExtraSparkWorker int    `json:"extraSparkWorker"`
+}
+
+/**
+Parsed schedule of the extra idle Spark workers, the first active window wins
+ */
+type extraSparkWorkerSchedule struct {
+	windows          []k8s_util.CronWindow
+	extraSparkWorker []int
+	location         *time.Location
+}
+
+/**
+This function parses the windows of a validated configuration, an unknown time zone falls back to UTC
+ */
+func newExtraSparkWorkerSchedule(windows []ExtraSparkWorkerWindow, timeZone string) extraSparkWorkerSchedule {
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	schedule := extraSparkWorkerSchedule{location: location}
+	for _, window := range windows {
+		cronWindow, err := k8s_util.ParseCronWindow(window.Schedule)
+		if err != nil {
+			continue
+		}
+		schedule.windows = append(schedule.windows, cronWindow)
+		schedule.extraSparkWorker = append(schedule.extraSparkWorker, window.ExtraSparkWorker)
+	}
+	return schedule
+}
+
+/**
+This function returns the number of extra idle Spark workers at timeNow, defaultExtra when no window is active
+ */
+func (schedule extraSparkWorkerSchedule) extraSparkWorkerAt(timeNow time.Time, defaultExtra int) int {
+	timeNow = timeNow.In(schedule.location)
+	for i, window := range schedule.windows {
+		if window.Active(timeNow) {
+			return schedule.extraSparkWorker[i]
+		}
+	}
+	return defaultExtra
+}
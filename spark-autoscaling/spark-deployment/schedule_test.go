@@ -0,0 +1,24 @@
+package spark_deployment
+
+import (
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+func TestExtraSparkWorkerSchedule(t *testing.T) {
+	schedule := newExtraSparkWorkerSchedule([]ExtraSparkWorkerWindow{
+		{Schedule: "0 8 * * 1-5 for 10h", ExtraSparkWorker: 3},
+		{Schedule: "0 18 * * 1-5 for 14h", ExtraSparkWorker: 0},
+	}, "America/Edmonton")
+	location, err := time.LoadLocation("America/Edmonton")
+	assert.NilError(t, err)
+	// office hours on Monday
+	assert.Equal(t, schedule.extraSparkWorkerAt(time.Date(2019, time.June, 17, 9, 0, 0, 0, location), 1), 3)
+	// overnight
+	assert.Equal(t, schedule.extraSparkWorkerAt(time.Date(2019, time.June, 18, 2, 0, 0, 0, location), 1), 0)
+	// the weekend falls back to EXTRA_SPARK_WORKER
+	assert.Equal(t, schedule.extraSparkWorkerAt(time.Date(2019, time.June, 22, 12, 0, 0, 0, location), 1), 1)
+	// the time zone of the schedule is used whatever the time zone of the time
+	assert.Equal(t, schedule.extraSparkWorkerAt(time.Date(2019, time.June, 17, 15, 0, 0, 0, time.UTC), 1), 3)
+}
@@ -1,11 +1,13 @@
 package spark_deployment
 
 import (
-	"github.com/json-iterator/go"
+	"context"
+	"fmt"
 	"github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
 	"log"
-	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,102 +17,274 @@ This struct contains data for a Spark cluster
 type SparkCluster struct {
 	sparkMasterDeployment *SparkMasterDeployment
 	sparkWorkerDeployment *SparkWorkerDeployment
+	extraSparkWorkerSchedule extraSparkWorkerSchedule
+	dryRun bool	//only log the pods which would be created or deleted instead of touching the cluster
+	maxScaleStep int	//maximum number of workers created or deleted at once
+	stabilizer *scaleStabilizer	//stabilization windows and cooldowns of the scaling
+	idleTracker *workerIdleTracker	//since when the ALIVE workers are idle
+	dryRunIntent *k8s_util.DryRunIntent	//scale action intended by the last rounds in dry-run mode
 }
 
 /**
-Constructor for SparkCluster struct
+Constructor for SparkCluster struct, config is expected to be validated
  */
-func NewSparkCluster(inClusterDeployment bool) *SparkCluster{
-	sparkDeploymentClient:= k8s_util.NewDeploymentClient(inClusterDeployment,os.Getenv("SPARK_CLUSTER_NAMESPACE"),)
+func NewSparkCluster(config SparkClusterConfig) *SparkCluster{
+	sparkDeploymentClient:= k8s_util.NewDeploymentClient(config.InCluster,config.Namespace,)
+	clusterInfoTimeout, _ := time.ParseDuration(config.ClusterInfoTimeout)
+	decommissionTimeout, _ := time.ParseDuration(config.WorkerDecommissionTimeout)
 	sparkWorkerDeploymentResource:= k8s_util.NewDeploymentResource(
-		os.Getenv("SPARK_WORKER_CORES"),
-		os.Getenv("SPARK_WORKER_MEM"),
-		os.Getenv("SPARK_WORKER_CONTAINER_CPU"),
-		os.Getenv("SPARK_WORKER_CONTAINER_MEM"))
+		config.Worker.Cores,
+		config.Worker.Mem,
+		config.Worker.ContainerCpu,
+		config.Worker.ContainerMem)
 	sparkMasterDeploymentResource:=k8s_util.NewDeploymentResource(
-		os.Getenv("SPARK_MASTER_CORES"),
-		os.Getenv("SPARK_MASTER_MEM"),
-		os.Getenv("SPARK_MASTER_CONTAINER_CPU"),
-		os.Getenv("SPARK_MASTER_CONTAINER_MEM"))
+		config.Master.Cores,
+		config.Master.Mem,
+		config.Master.ContainerCpu,
+		config.Master.ContainerMem)
 	sparkMasterDeployment:= NewSparkMasterDeployment(
 		sparkDeploymentClient,
-		os.Getenv("SPARK_MASTER_IMAGE"),
+		config.Master.Image,
+		config.Master.Pool,
 		sparkMasterDeploymentResource)
 	sparkWorkerDeployment:= NewSparkWorkerDeployment(
 		//TODO: check core max does not work properly problem
 		sparkDeploymentClient,
-		os.Getenv("SPARK_WORKER_IMAGE"),
+		config.Worker.Image,
+		config.Worker.Pool,
 		sparkWorkerDeploymentResource,
-		os.Getenv("SPARK_WORKER_OPTS"))
+		config.Worker.Opts,
+		config.ExtraSparkWorker,
+		NewSparkMasterClient(config.ClusterInfoURL,clusterInfoTimeout,config.ClusterInfoRetries),
+		config.WorkerDecommission=="master",
+		decommissionTimeout,
+		config.ScaleInPolicy)
 	return &SparkCluster{
 		sparkMasterDeployment:sparkMasterDeployment,
 		sparkWorkerDeployment:sparkWorkerDeployment,
+		extraSparkWorkerSchedule:newExtraSparkWorkerSchedule(config.ExtraSparkWorkerSchedule,config.TimeZone),
+		dryRun:config.DryRun,
+		maxScaleStep:config.MaxScaleStep,
+		stabilizer:newScaleStabilizer(config.Behavior),
+		idleTracker:newWorkerIdleTracker(parseBehaviorDuration(config.Behavior.WorkerIdleTime)),
+		dryRunIntent:&k8s_util.DryRunIntent{},
 	}
 }
 
 
 /**
-This function is used to deploy spark cluster and start autoscaling
+This function returns the client used to deploy the Spark cluster
  */
-func (sparkCluster SparkCluster) Deploy(cleanExisting bool) {
-	if cleanExisting {
+func (sparkCluster SparkCluster) DeploymentClient() *k8s_util.DeploymentClient {
+	return sparkCluster.sparkWorkerDeployment.deploymentClient
+}
+
+/**
+This function is used to deploy spark cluster and start autoscaling, it returns when ctx is cancelled.
+In dry-run mode the existing Spark cluster is never cleaned, the pods which would be deleted are only logged.
+ */
+func (sparkCluster SparkCluster) Deploy(ctx context.Context, cleanExisting bool) {
+	if sparkCluster.dryRun {
+		log.Println("Dry run: the pods which would be created or deleted are only logged")
+	}
+	if cleanExisting && sparkCluster.dryRun {
+		hasError := false
+		workers:=sparkCluster.sparkWorkerDeployment.getWorkers(&hasError)
+		log.Printf("Dry run: %d worker pods, the Spark master deployment %s and its services would be deleted and redeployed\n",
+			len(workers),sparkCluster.sparkMasterDeployment.sparkMasterName)
+		sparkCluster.autoScale(ctx)
+	} else if cleanExisting {
 		sparkCluster.sparkWorkerDeployment.removeAllWorker()
 		sparkCluster.sparkMasterDeployment.Deploy()
-		sparkCluster.autoScale()
+		sparkCluster.autoScale(ctx)
 	} else{
-		sparkCluster.autoScale()
+		sparkCluster.autoScale(ctx)
 	}
 }
 
 /**
 This function is to auto scale the Spark cluster with keep scaling in or out the cluster through
-tracking the utilization of workers
+tracking the utilization of workers.
+It returns when ctx is cancelled. A worker pod which is already created or deleted is left to kubernetes,
+only the wait for the worker joining or leaving the cluster is abandoned, and no new pod is created or deleted
+after ctx is cancelled.
  */
-func (sparkCluster SparkCluster) autoScale()  {
-	for {
-		clusterInfo,err:=sparkCluster.sparkWorkerDeployment.getClusterInfo()
+func (sparkCluster SparkCluster) autoScale(ctx context.Context)  {
+	for ctx.Err() == nil {
+		status,err:=sparkCluster.sparkWorkerDeployment.sparkMasterClient.Status(ctx)
 		if err != nil {
 			log.Println(err)
 		}else{
-			// count cores in use based on the information from Spark master json
-			coresused:=jsoniter.Get(clusterInfo, "coresused").ToInt()
+			// count cores and memory in use based on the information from Spark master json
+			coresused:=status.CoresUsed
 			coresPerWorker, _ :=strconv.Atoi(sparkCluster.sparkWorkerDeployment.deploymentResource.Cores)
-			targetCores:=coresused+coresPerWorker*sparkCluster.sparkWorkerDeployment.extraSparkWorker
+			memoryPerWorker, _ :=parseSparkMemoryMB(sparkCluster.sparkWorkerDeployment.deploymentResource.Mem)
+			// the number of extra idle workers can change with the time of the day
+			extraSparkWorker:=sparkCluster.extraSparkWorkerSchedule.extraSparkWorkerAt(time.Now(),
+				sparkCluster.sparkWorkerDeployment.extraSparkWorker)
+			// the applications waiting for executors and the drivers waiting for a worker get their cores on top
+			// of the extra idle workers
+			waitingCores:=status.waitingCores(coresPerWorker)
+			waitingMemory:=status.waitingMemory(coresPerWorker)
+			targetCores:=coresused+waitingCores+coresPerWorker*extraSparkWorker
+			targetMemory:=status.MemoryUsed+waitingMemory+memoryPerWorker*extraSparkWorker
 			// count spark worker num based on nums of spark worker pods (including the pending ones)
 			hasError := false
-			currWorkerNum:= len(sparkCluster.sparkWorkerDeployment.getWorkers(&hasError))
-			if hasError {time.Sleep(1000*time.Millisecond);continue}
+			workers:=sparkCluster.sparkWorkerDeployment.getWorkers(&hasError)
+			if hasError {k8s_util.SleepWithContext(ctx,1000*time.Millisecond);continue}
+			currWorkerNum:= len(workers)
 			cores:=currWorkerNum*coresPerWorker
-			log.Println("target cores:",targetCores)
-			log.Println("current cores:",cores)
-			if cores<targetCores{
-				sparkCluster.scaleOut()
+			log.Println("target cores:",targetCores,"(waiting applications:",waitingCores,") target memory:",targetMemory,
+				"MB (waiting applications:",waitingMemory,"MB)")
+			log.Println("current cores:",cores,"current memory:",currWorkerNum*memoryPerWorker,"MB")
+			coresUsedGauge.Set(float64(coresused))
+			targetCoresGauge.Set(float64(targetCores))
+			memoryUsedGauge.Set(float64(status.MemoryUsed))
+			waitingCoresGauge.Set(float64(waitingCores))
+			waitingMemoryGauge.Set(float64(waitingMemory))
+			targetMemoryGauge.Set(float64(targetMemory))
+			extraWorkersGauge.Set(float64(extraSparkWorker))
+			currentCoresGauge.Set(float64(cores))
+			idleWorkersGauge.Set(float64(len(status.idleWorkers())))
+			recordWorkerPods(workers)
+			sparkCluster.idleTracker.update(time.Now(),status)
+			// a short burst or a short idle moment is smoothed out by the stabilization windows
+			desiredWorkerNum:=desiredWorkerNum(targetCores,coresPerWorker,targetMemory,memoryPerWorker)
+			stabilizedWorkerNum:=sparkCluster.stabilizer.stabilize(time.Now(),desiredWorkerNum,currWorkerNum)
+			desiredWorkersGauge.Set(float64(stabilizedWorkerNum))
+			delta:=workerDelta(stabilizedWorkerNum,currWorkerNum,sparkCluster.maxScaleStep)
+			if delta!=0 && sparkCluster.stabilizer.inCooldown(time.Now(),delta) {
+				log.Println("desired workers:",stabilizedWorkerNum,"waiting for the cooldown of the last scaling")
+				delta=0
+			}else{
+				// a round waiting for the cooldown still intends the action of the previous round
+				sparkCluster.dryRunIntent.NextRound()
 			}
-			if cores>targetCores {
-				sparkCluster.scaleIn()
+			if delta>0{
+				sparkCluster.scaleOut(ctx,delta)
+				sparkCluster.stabilizer.recordScaleOut(time.Now())
+			}
+			if delta<0 && sparkCluster.scaleIn(ctx,-delta)>0 {
+				sparkCluster.stabilizer.recordScaleIn(time.Now())
 			}
 		}
-		time.Sleep(1000*time.Millisecond)
+		k8s_util.SleepWithContext(ctx,1000*time.Millisecond)
 	}
+	log.Println("Spark cluster autoscaling is stopped")
 }
 
 /**
-This function is to scale out the Spark cluster by adding a new worker to the cluster
+This function returns the number of workers providing at least targetCores and targetMemory (in MB), so the workers
+are scaled on whichever of cores and memory is more constrained, e.g. memory-heavy notebook sessions
  */
-func (sparkCluster SparkCluster) scaleOut()  {
-	err := sparkCluster.sparkWorkerDeployment.addWorker()
-	if err != nil {
-		log.Println(err)
+func desiredWorkerNum(targetCores int, coresPerWorker int, targetMemory int, memoryPerWorker int) int {
+	desired:=0
+	if coresPerWorker>0 {
+		desired=(targetCores+coresPerWorker-1)/coresPerWorker
+	}
+	if memoryPerWorker>0 {
+		if byMemory:=(targetMemory+memoryPerWorker-1)/memoryPerWorker; byMemory>desired {
+			desired=byMemory
+		}
 	}
+	return desired
 }
 
 /**
-This function is to scale in the Spark cluster by deleting an random idle worker
+This function returns the number of workers to add (positive) or to remove (negative) to reach desiredWorkerNum,
+limited to maxStep workers at once
  */
-func (sparkCluster SparkCluster) scaleIn()  {
-	podToRemove:=sparkCluster.sparkWorkerDeployment.podToRemove()
-	err := sparkCluster.sparkWorkerDeployment.removeWorker(podToRemove)
-	if err != nil {
-		log.Println(err)
+func workerDelta(desiredWorkerNum int, currWorkerNum int, maxStep int) int {
+	delta:=desiredWorkerNum-currWorkerNum
+	if maxStep>0 && delta>maxStep {
+		delta=maxStep
+	}
+	if maxStep>0 && delta < -maxStep {
+		delta=-maxStep
 	}
-}
\ No newline at end of file
+	return delta
+}
+
+/**
+This function converts a Spark memory string like "512m" or "2g" to MB, the unit is required
+ */
+func parseSparkMemoryMB(memory string) (int, error) {
+	units:=map[string]float64{"k":1.0/1024,"m":1,"g":1024,"t":1024*1024}
+	lower:=strings.TrimSuffix(strings.ToLower(strings.TrimSpace(memory)),"b")
+	if len(lower)<2 {
+		return 0,fmt.Errorf("invalid Spark memory %q, expected e.g. \"512m\" or \"2g\"",memory)
+	}
+	unit,exist:=units[lower[len(lower)-1:]]
+	value,err:=strconv.Atoi(lower[:len(lower)-1])
+	if !exist || err!=nil || value<0 {
+		return 0,fmt.Errorf("invalid Spark memory %q, expected e.g. \"512m\" or \"2g\"",memory)
+	}
+	return int(float64(value)*unit),nil
+}
+
+/**
+This function is to scale out the Spark cluster by adding count new workers to the cluster concurrently,
+it returns when all of them are added or failed
+ */
+func (sparkCluster SparkCluster) scaleOut(ctx context.Context, count int)  {
+	if sparkCluster.dryRun {
+		resource:=sparkCluster.sparkWorkerDeployment.deploymentResource
+		log.Printf("Dry run: %d worker pods would be created with %s cores, %s memory (container cpu %s, memory %s) each\n",
+			count,resource.Cores,resource.Mem,resource.ContainerCpu,resource.ContainerMem)
+		// the same workers are intended at every round while the load doesn't change, they are counted once
+		if sparkCluster.dryRunIntent.Intend(fmt.Sprintf("%s %d",dryRunActionAddWorker,count)) {
+			dryRunActions.WithLabelValues(dryRunActionAddWorker).Add(float64(count))
+		}
+		return
+	}
+	var waitGroup sync.WaitGroup
+	for i:=0;i<count;i++{
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			timeBegin:=time.Now()
+			err := sparkCluster.sparkWorkerDeployment.addWorker(ctx)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			addWorkerDuration.Observe(time.Since(timeBegin).Seconds())
+			scaleOutCounter.Inc()
+		}()
+	}
+	waitGroup.Wait()
+}
+
+/**
+This function is to scale in the Spark cluster by deleting up to count idle workers concurrently,
+it returns the number of workers it tried to remove when all of them are removed or failed
+ */
+func (sparkCluster SparkCluster) scaleIn(ctx context.Context, count int) int {
+	podsToRemove:=sparkCluster.sparkWorkerDeployment.podsToRemove(ctx,count,sparkCluster.idleTracker)
+	if sparkCluster.dryRun {
+		for _,podToRemove:=range podsToRemove{
+			log.Println("Dry run: worker pod "+podToRemove+" would be deleted")
+		}
+		if sparkCluster.dryRunIntent.Intend(fmt.Sprintf("%s %d",dryRunActionRemoveWorker,len(podsToRemove))) {
+			dryRunActions.WithLabelValues(dryRunActionRemoveWorker).Add(float64(len(podsToRemove)))
+		}
+		return len(podsToRemove)
+	}
+	var waitGroup sync.WaitGroup
+	for _,podToRemove:=range podsToRemove{
+		waitGroup.Add(1)
+		go func(podToRemove string) {
+			defer waitGroup.Done()
+			timeBegin:=time.Now()
+			err := sparkCluster.sparkWorkerDeployment.removeWorker(ctx,podToRemove)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			removeWorkerDuration.Observe(time.Since(timeBegin).Seconds())
+			scaleInCounter.Inc()
+		}(podToRemove)
+	}
+	waitGroup.Wait()
+	return len(podsToRemove)
+}
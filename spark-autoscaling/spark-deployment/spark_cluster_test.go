@@ -0,0 +1,99 @@
+package spark_deployment
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func TestDryRunDoesNotTouchTheCluster(t *testing.T) {
+	pods := []apiv1.Pod{
+		newFakeWorkerPod("spark-worker-1", apiv1.PodPending),
+		newFakeWorkerPod("spark-worker-2", apiv1.PodPending),
+	}
+	cluster, clientSet, master := newFakeSparkCluster(pods, `{"coresused": 0, "workers": []}`)
+	defer master.Close()
+	cluster.dryRun = true
+	addWorkerActions := testutil.ToFloat64(dryRunActions.WithLabelValues(dryRunActionAddWorker))
+	removeWorkerActions := testutil.ToFloat64(dryRunActions.WithLabelValues(dryRunActionRemoveWorker))
+
+	// the same workers intended by two rounds are counted once
+	cluster.scaleOut(context.Background(), 2)
+	cluster.dryRunIntent.NextRound()
+	cluster.scaleOut(context.Background(), 2)
+	// the pending workers are the first candidates to be removed
+	cluster.dryRunIntent.NextRound()
+	cluster.scaleIn(context.Background(), 3)
+	cluster.dryRunIntent.NextRound()
+	cluster.scaleIn(context.Background(), 3)
+	assert.DeepEqual(t, workerPodNames(clientSet), []string{"spark-worker-1", "spark-worker-2"})
+	assert.Equal(t, testutil.ToFloat64(dryRunActions.WithLabelValues(dryRunActionAddWorker)), addWorkerActions+2)
+	assert.Equal(t, testutil.ToFloat64(dryRunActions.WithLabelValues(dryRunActionRemoveWorker)), removeWorkerActions+2)
+	// the workers intended again after a round without scaling are counted again
+	cluster.dryRunIntent.NextRound()
+	cluster.dryRunIntent.NextRound()
+	cluster.scaleOut(context.Background(), 2)
+	assert.Equal(t, testutil.ToFloat64(dryRunActions.WithLabelValues(dryRunActionAddWorker)), addWorkerActions+4)
+
+	// cleaning the existing deployment is only logged too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cluster.Deploy(ctx, true)
+	assert.DeepEqual(t, workerPodNames(clientSet), []string{"spark-worker-1", "spark-worker-2"})
+}
+
+func TestScaleBySeveralWorkers(t *testing.T) {
+	pods := []apiv1.Pod{
+		newFakeWorkerPod("spark-worker-1", apiv1.PodPending),
+		newFakeWorkerPod("spark-worker-2", apiv1.PodPending),
+		newFakeWorkerPod("spark-worker-3", apiv1.PodPending),
+	}
+	cluster, clientSet, master := newFakeSparkCluster(pods, `{"coresused": 0, "workers": []}`)
+	defer master.Close()
+
+	cluster.scaleOut(context.Background(), 3)
+	assert.Equal(t, len(workerPodNames(clientSet)), 6)
+	cluster.scaleIn(context.Background(), 2)
+	assert.Equal(t, len(workerPodNames(clientSet)), 4)
+	// only the pending workers can be removed while no worker is idle
+	cluster.scaleIn(context.Background(), 2)
+	assert.Equal(t, len(workerPodNames(clientSet)), 3)
+}
+
+func TestWorkerDelta(t *testing.T) {
+	assert.Equal(t, workerDelta(3, 0, 0), 3)
+	assert.Equal(t, workerDelta(3, 0, 2), 2)
+	assert.Equal(t, workerDelta(3, 3, 2), 0)
+	assert.Equal(t, workerDelta(1, 6, 0), -5)
+	assert.Equal(t, workerDelta(1, 6, 3), -3)
+}
+
+func TestDesiredWorkerNum(t *testing.T) {
+	// 5 cores need 3 workers of 2 cores
+	assert.Equal(t, desiredWorkerNum(5, 2, 0, 2048), 3)
+	// memory is more constrained: 9000MB need 5 workers of 2g while 2 cores need 1 worker
+	assert.Equal(t, desiredWorkerNum(2, 2, 9000, 2048), 5)
+	assert.Equal(t, desiredWorkerNum(2, 0, 0, 0), 0)
+	// an application WAITING for an executor bigger than the free memory of the worker needs a new worker even if
+	// the worker has free cores for it
+	status, err := parseSparkMasterStatus([]byte(`{"coresused": 1, "memoryused": 3072, "activeapps": [
+		{"id": "app-20190620000000-0001", "cores": 2, "memoryperslave": 2048, "state": "WAITING"}
+	]}`))
+	assert.NilError(t, err)
+	assert.Equal(t, desiredWorkerNum(status.CoresUsed+status.waitingCores(4), 4,
+		status.MemoryUsed+status.waitingMemory(4), 4096), 2)
+}
+
+func TestParseSparkMemoryMB(t *testing.T) {
+	for memory, expected := range map[string]int{"2g": 2048, "512m": 512, "1G": 1024, "1024mb": 1024, "1t": 1048576, "2048k": 2} {
+		parsed, err := parseSparkMemoryMB(memory)
+		assert.NilError(t, err, memory)
+		assert.Equal(t, parsed, expected, memory)
+	}
+	for _, memory := range []string{"", "2", "2 GB", "g", "-1g", "2Gi"} {
+		_, err := parseSparkMemoryMB(memory)
+		assert.ErrorContains(t, err, "invalid Spark memory", memory)
+	}
+}
@@ -0,0 +1,278 @@
+package spark_deployment
+
+import (
+	"context"
+	"fmt"
+	"github.com/json-iterator/go"
+	"github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/**
+Status of the Spark cluster reported by the json endpoint of the Spark master web UI, memory is in MB
+ */
+type SparkMasterStatus struct {
+	URL              string            `json:"url"`
+	Workers          []SparkWorkerInfo `json:"workers"`
+	AliveWorkers     int               `json:"aliveworkers"`
+	Cores            int               `json:"cores"`
+	CoresUsed        int               `json:"coresused"`
+	Memory           int               `json:"memory"`
+	MemoryUsed       int               `json:"memoryused"`
+	ActiveApps       []SparkAppInfo    `json:"activeapps"`
+	CompletedApps    []SparkAppInfo    `json:"completedapps"`
+	ActiveDrivers    []SparkDriverInfo `json:"activedrivers"`
+	CompletedDrivers []SparkDriverInfo `json:"completeddrivers"`
+	Status           string            `json:"status"`
+}
+
+/**
+A worker registered to the Spark master, State is ALIVE, DEAD, DECOMMISSIONED or UNKNOWN
+ */
+type SparkWorkerInfo struct {
+	ID            string `json:"id"`
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	WebUIAddress  string `json:"webuiaddress"`
+	Cores         int    `json:"cores"`
+	CoresUsed     int    `json:"coresused"`
+	CoresFree     int    `json:"coresfree"`
+	Memory        int    `json:"memory"`
+	MemoryUsed    int    `json:"memoryused"`
+	MemoryFree    int    `json:"memoryfree"`
+	State         string `json:"state"`
+	LastHeartbeat int64  `json:"lastheartbeat"`
+}
+
+/**
+An application of the Spark master, State is WAITING, RUNNING, FINISHED, FAILED, KILLED or UNKNOWN
+ */
+type SparkAppInfo struct {
+	ID             string `json:"id"`
+	StartTime      int64  `json:"starttime"`
+	Name           string `json:"name"`
+	Cores          int    `json:"cores"`
+	User           string `json:"user"`
+	MemoryPerSlave int    `json:"memoryperslave"`
+	SubmitDate     string `json:"submitdate"`
+	State          string `json:"state"`
+	Duration       int64  `json:"duration"`
+}
+
+/**
+A driver submitted to the Spark master in cluster mode, State is SUBMITTED, RUNNING, FINISHED, FAILED, ...
+ */
+type SparkDriverInfo struct {
+	ID         string `json:"id"`
+	StartTime  string `json:"starttime"`
+	State      string `json:"state"`
+	Cores      int    `json:"cores"`
+	Memory     int    `json:"memory"`
+	SubmitDate string `json:"submitdate"`
+	Worker     string `json:"worker"`
+	MainClass  string `json:"mainclass"`
+}
+
+/**
+This function returns the ALIVE workers without any core in use
+ */
+func (status SparkMasterStatus) idleWorkers() []SparkWorkerInfo {
+	idleWorkers := []SparkWorkerInfo{}
+	for _, worker := range status.Workers {
+		if worker.State == "ALIVE" && worker.CoresUsed == 0 {
+			idleWorkers = append(idleWorkers, worker)
+		}
+	}
+	return idleWorkers
+}
+
+
+/**
+This function returns the DECOMMISSIONED workers without any core in use, they don't get new executors and
+are only waiting for their pod to be deleted
+ */
+func (status SparkMasterStatus) decommissionedIdleWorkers() []SparkWorkerInfo {
+	decommissionedWorkers := []SparkWorkerInfo{}
+	for _, worker := range status.Workers {
+		if worker.State == "DECOMMISSIONED" && worker.CoresUsed == 0 {
+			decommissionedWorkers = append(decommissionedWorkers, worker)
+		}
+	}
+	return decommissionedWorkers
+}
+
+/**
+This function returns the worker bound to host, the DEAD workers left by a previous worker on the same host are
+ignored. It returns false if the Spark master doesn't know any other worker on host.
+ */
+func (status SparkMasterStatus) workerOnHost(host string) (SparkWorkerInfo, bool) {
+	for _, worker := range status.Workers {
+		if worker.Host == host && worker.State != "DEAD" {
+			return worker, true
+		}
+	}
+	return SparkWorkerInfo{}, false
+}
+
+/**
+This function returns the cores requested by the WAITING applications, which don't have any executor yet, and by
+the drivers SUBMITTED in cluster mode which wait for a worker to run on and have no application yet.
+An application without a maximum of cores (spark.cores.max) reports 0 cores and is counted as one worker, a driver
+without cores is counted as the 1 core of the default spark.driver.cores.
+ */
+func (status SparkMasterStatus) waitingCores(coresPerWorker int) int {
+	waitingCores := 0
+	for _, app := range status.ActiveApps {
+		if app.State != "WAITING" {
+			continue
+		}
+		if app.Cores > 0 {
+			waitingCores += app.Cores
+		} else {
+			waitingCores += coresPerWorker
+		}
+	}
+	for _, driver := range status.ActiveDrivers {
+		if driver.State != "SUBMITTED" && driver.State != "WAITING" {
+			continue
+		}
+		if driver.Cores > 0 {
+			waitingCores += driver.Cores
+		} else {
+			waitingCores += 1
+		}
+	}
+	return waitingCores
+}
+
+/**
+This function returns the memory in MB requested by the WAITING applications and by the drivers SUBMITTED in cluster
+mode, counted like waitingCores. A WAITING application gets one executor of MemoryPerSlave on every worker giving it
+cores, so it requests MemoryPerSlave for every coresPerWorker of its cores, and for one worker without spark.cores.max.
+ */
+func (status SparkMasterStatus) waitingMemory(coresPerWorker int) int {
+	waitingMemory := 0
+	for _, app := range status.ActiveApps {
+		if app.State != "WAITING" {
+			continue
+		}
+		executors := 1
+		if app.Cores > 0 && coresPerWorker > 0 {
+			executors = (app.Cores + coresPerWorker - 1) / coresPerWorker
+		}
+		waitingMemory += executors * app.MemoryPerSlave
+	}
+	for _, driver := range status.ActiveDrivers {
+		if driver.State == "SUBMITTED" || driver.State == "WAITING" {
+			waitingMemory += driver.Memory
+		}
+	}
+	return waitingMemory
+}
+
+/**
+SparkMasterClient reads the status of the Spark cluster from the json endpoint of the Spark master web UI,
+every request is given up after timeout, and a failed request is retried
+ */
+type SparkMasterClient struct {
+	url           string
+	httpClient    *http.Client
+	retries       int           //retries after the first attempt
+	retryInterval time.Duration //wait between two attempts
+}
+
+/**
+Constructor for SparkMasterClient struct, url is the json endpoint, e.g. http://spark-webui:8080/json
+ */
+func NewSparkMasterClient(url string, timeout time.Duration, retries int) *SparkMasterClient {
+	return &SparkMasterClient{
+		url:           url,
+		httpClient:    &http.Client{Timeout: timeout},
+		retries:       retries,
+		retryInterval: time.Second,
+	}
+}
+
+/**
+This function returns the status of the Spark cluster, the last error once all the attempts failed or ctx is cancelled
+ */
+func (client SparkMasterClient) Status(ctx context.Context) (SparkMasterStatus, error) {
+	status, err := client.getStatus(ctx)
+	for attempt := 0; err != nil && attempt < client.retries; attempt++ {
+		log.Println(err, ", retrying")
+		if !k8s_util.SleepWithContext(ctx, client.retryInterval) {
+			return status, err
+		}
+		status, err = client.getStatus(ctx)
+	}
+	return status, err
+}
+
+func (client SparkMasterClient) getStatus(ctx context.Context) (SparkMasterStatus, error) {
+	status := SparkMasterStatus{}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, client.url, nil)
+	if err != nil {
+		return status, err
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return status, fmt.Errorf("the Spark master can't be reached: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("the Spark master responded %s", response.Status)
+	}
+	responseData, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return status, err
+	}
+	return parseSparkMasterStatus(responseData)
+}
+
+/**
+This function asks the Spark master to decommission the workers on host: they don't get new executors anymore,
+the running executors are left to finish. It needs Spark 3.1 or later with spark.master.ui.decommission.allow.mode
+set to ALLOW on the master, and isn't retried.
+ */
+func (client SparkMasterClient) DecommissionWorker(ctx context.Context, host string) error {
+	// the json endpoint and the decommission endpoint are both served by the web UI of the master
+	killURL := strings.TrimSuffix(strings.TrimSuffix(client.url, "/"), "/json") + "/workers/kill/"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, killURL,
+		strings.NewReader(url.Values{"host": {host}}.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("the Spark master can't be reached to decommission the worker on %s: %v", host, err)
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("the Spark master has no worker to decommission on %s", host)
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("the Spark master doesn't allow to decommission the worker on %s, "+
+			"set spark.master.ui.decommission.allow.mode to ALLOW", host)
+	default:
+		return fmt.Errorf("the Spark master responded %s to decommission the worker on %s", response.Status, host)
+	}
+}
+
+/**
+This function decodes the json of the Spark master, unknown fields are ignored
+ */
+func parseSparkMasterStatus(data []byte) (SparkMasterStatus, error) {
+	status := SparkMasterStatus{}
+	if err := jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(data, &status); err != nil {
+		return status, fmt.Errorf("invalid json from the Spark master: %v", err)
+	}
+	return status, nil
+}
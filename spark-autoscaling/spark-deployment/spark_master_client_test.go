@@ -0,0 +1,160 @@
+package spark_deployment
+
+import (
+	"context"
+	"fmt"
+	"gotest.tools/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const sparkMasterJSON = `{
+	"url": "spark://spark-master:7077",
+	"workers": [
+		{"id": "worker-20190620000000-10.0.0.1-7078", "host": "10.0.0.1", "port": 7078, "cores": 2, "coresused": 0,
+		 "coresfree": 2, "memory": 2048, "memoryused": 0, "memoryfree": 2048, "state": "ALIVE", "lastheartbeat": 1561000000000},
+		{"id": "worker-20190620000000-10.0.0.2-7078", "host": "10.0.0.2", "port": 7078, "cores": 2, "coresused": 1,
+		 "coresfree": 1, "memory": 2048, "memoryused": 1024, "memoryfree": 1024, "state": "ALIVE", "lastheartbeat": 1561000000000},
+		{"id": "worker-20190620000000-10.0.0.3-7078", "host": "10.0.0.3", "port": 7078, "cores": 2, "coresused": 0,
+		 "state": "DEAD"},
+		{"id": "worker-20190620000000-10.0.0.4-7078", "host": "10.0.0.4", "port": 7078, "cores": 2, "coresused": 0,
+		 "state": "ALIVE"}
+	],
+	"aliveworkers": 3, "cores": 6, "coresused": 1, "memory": 6144, "memoryused": 1024,
+	"activeapps": [
+		{"id": "app-20190620000000-0001", "starttime": 1561000000000, "name": "notebook", "cores": 1, "user": "jovyan",
+		 "memoryperslave": 1024, "submitdate": "Thu Jun 20 00:00:00 UTC 2019", "state": "RUNNING", "duration": 60000}
+	],
+	"completedapps": [],
+	"activedrivers": [
+		{"id": "driver-20190620000000-0000", "starttime": "1561000000000", "state": "SUBMITTED", "cores": 1,
+		 "memory": 1024, "submitdate": "Thu Jun 20 00:00:00 UTC 2019", "worker": "None", "mainclass": "Main"}
+	],
+	"completeddrivers": [],
+	"status": "ALIVE"
+}`
+
+func TestParseSparkMasterStatus(t *testing.T) {
+	status, err := parseSparkMasterStatus([]byte(sparkMasterJSON))
+	assert.NilError(t, err)
+	assert.Equal(t, status.CoresUsed, 1)
+	assert.Equal(t, status.MemoryUsed, 1024)
+	assert.Equal(t, len(status.Workers), 4)
+	assert.Equal(t, status.Workers[1].Host, "10.0.0.2")
+	assert.Equal(t, status.ActiveApps[0].State, "RUNNING")
+	assert.Equal(t, status.ActiveDrivers[0].State, "SUBMITTED")
+	idleWorkers := status.idleWorkers()
+	assert.Equal(t, len(idleWorkers), 2)
+	assert.Equal(t, idleWorkers[1].Host, "10.0.0.4")
+
+	status, err = parseSparkMasterStatus([]byte(`{}`))
+	assert.NilError(t, err)
+	assert.Equal(t, len(status.idleWorkers()), 0)
+	_, err = parseSparkMasterStatus([]byte(`<html>Spark Master</html>`))
+	assert.ErrorContains(t, err, "invalid json")
+}
+
+func TestWaitingCores(t *testing.T) {
+	status, err := parseSparkMasterStatus([]byte(`{"activeapps": [
+		{"id": "app-20190620000000-0001", "cores": 1, "state": "RUNNING"},
+		{"id": "app-20190620000000-0002", "cores": 8, "state": "WAITING"},
+		{"id": "app-20190620000000-0003", "state": "WAITING"}
+	]}`))
+	assert.NilError(t, err)
+	// the application without spark.cores.max is counted as one worker of 2 cores
+	assert.Equal(t, status.waitingCores(2), 10)
+	assert.Equal(t, SparkMasterStatus{}.waitingCores(2), 0)
+}
+
+func TestWaitingMemory(t *testing.T) {
+	status, err := parseSparkMasterStatus([]byte(`{"activeapps": [
+		{"id": "app-20190620000000-0001", "cores": 1, "memoryperslave": 1024, "state": "RUNNING"},
+		{"id": "app-20190620000000-0002", "cores": 3, "memoryperslave": 4096, "state": "WAITING"},
+		{"id": "app-20190620000000-0003", "memoryperslave": 2048, "state": "WAITING"}
+	], "activedrivers": [
+		{"id": "driver-20190620000000-0000", "state": "SUBMITTED", "memory": 512, "worker": "None"},
+		{"id": "driver-20190620000000-0001", "state": "RUNNING", "memory": 1024, "worker": "worker-20190620000000-10.0.0.1-7078"}
+	]}`))
+	assert.NilError(t, err)
+	// the 3 cores of workers of 2 cores are 2 executors, the application without spark.cores.max is one executor
+	assert.Equal(t, status.waitingMemory(2), 2*4096+2048+512)
+	assert.Equal(t, SparkMasterStatus{}.waitingMemory(2), 0)
+}
+
+func TestWaitingCoresOfDrivers(t *testing.T) {
+	// the SUBMITTED driver waits for a worker and has no application yet, the RUNNING one already got its cores
+	status, err := parseSparkMasterStatus([]byte(`{"activedrivers": [
+		{"id": "driver-20190620000000-0000", "state": "SUBMITTED", "cores": 2, "worker": "None"},
+		{"id": "driver-20190620000000-0001", "state": "RUNNING", "cores": 1, "worker": "worker-20190620000000-10.0.0.1-7078"},
+		{"id": "driver-20190620000000-0002", "state": "SUBMITTED", "worker": "None"}
+	]}`))
+	assert.NilError(t, err)
+	assert.Equal(t, status.waitingCores(4), 3)
+	status, err = parseSparkMasterStatus([]byte(sparkMasterJSON))
+	assert.NilError(t, err)
+	assert.Equal(t, status.waitingCores(2), 1)
+}
+
+func TestDecommissionWorker(t *testing.T) {
+	decommissioned := []string{}
+	allowed := true
+	master := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost || request.URL.Path != "/workers/kill/" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !allowed {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		decommissioned = append(decommissioned, request.FormValue("host"))
+	}))
+	defer master.Close()
+	client := NewSparkMasterClient(master.URL+"/json", time.Second, 0)
+	assert.NilError(t, client.DecommissionWorker(context.Background(), "172.30.0.2"))
+	assert.DeepEqual(t, decommissioned, []string{"172.30.0.2"})
+
+	allowed = false
+	err := client.DecommissionWorker(context.Background(), "172.30.0.3")
+	assert.ErrorContains(t, err, "spark.master.ui.decommission.allow.mode")
+}
+
+func TestSparkMasterClientRetries(t *testing.T) {
+	requests := 0
+	master := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if requests < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprint(writer, sparkMasterJSON)
+	}))
+	defer master.Close()
+	client := NewSparkMasterClient(master.URL, time.Second, 2)
+	client.retryInterval = time.Millisecond
+	status, err := client.Status(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, status.CoresUsed, 1)
+	assert.Equal(t, requests, 3)
+
+	requests = 0
+	client.retries = 1
+	_, err = client.Status(context.Background())
+	assert.ErrorContains(t, err, "503")
+	assert.Equal(t, requests, 2)
+}
+
+func TestSparkMasterClientTimeout(t *testing.T) {
+	master := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = fmt.Fprint(writer, sparkMasterJSON)
+	}))
+	defer master.Close()
+	client := NewSparkMasterClient(master.URL, 20*time.Millisecond, 0)
+	timeBegin := time.Now()
+	_, err := client.Status(context.Background())
+	assert.ErrorContains(t, err, "can't be reached")
+	assert.Assert(t, time.Since(timeBegin) < 200*time.Millisecond)
+}
@@ -6,7 +6,6 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
-	"os"
 )
 /**
 This struct contains data related to spark master
@@ -29,6 +28,7 @@ Constructor for SparkMasterDeployment
  */
 func NewSparkMasterDeployment(deploymentClient *k8s_util.DeploymentClient,
 	image_name string,
+	pool string,
 	resource *k8s_util.DeploymentResource) *SparkMasterDeployment{
 	return &SparkMasterDeployment{
 		deploymentClient: deploymentClient,
@@ -36,10 +36,10 @@ func NewSparkMasterDeployment(deploymentClient *k8s_util.DeploymentClient,
 		sparkPath: "/usr/spark",
 		labels:map[string]string{
 			"component": "spark-master",
-			"pool": os.Getenv("SPARK_MASTER_POOL"),
+			"pool": pool,
 		},
 		nodeSelector:map[string]string{
-			"pool": os.Getenv("SPARK_MASTER_POOL"),
+			"pool": pool,
 		},
 		sparkMasterName: "spark-master",
 		sparkMasterSerivcePort:"7077",
@@ -1,25 +1,32 @@
 package spark_deployment
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
-	"github.com/json-iterator/go"
 	"github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
-	"io/ioutil"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	"log"
-	"net/http"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 )
 
-const NodePending = "Pending"
+/**
+Every worker binds to the IP of its pod, given by the downward API, and to this port, so the worker reported by
+the Spark master is mapped to its pod from the pod status
+ */
+const sparkWorkerPort = 7078
+
+/**
+removeWorker marks the pod of a worker with this annotation, holding the time in RFC3339, while it releases the
+worker, so the next cycles don't pick the worker again. A mark older than the decommission timeout plus
+releaseMarkGracePeriod is ignored, it was left by an autoscaler which stopped meanwhile.
+ */
+const releasingAnnotation = "spark-autoscaler/releasing"
+const releaseMarkGracePeriod = 2*time.Minute
 
 /**
 SparkWorkerDeployment struct contains data related to spark workers
@@ -33,9 +40,12 @@ type SparkWorkerDeployment struct{
 	sparkService         string
 	sparkPath            string
 	sparkMasterWebuiPort string
-	workerNameToNet      map[string]string
 	deploymentResource   *k8s_util.DeploymentResource
 	extraSparkWorker     int
+	sparkMasterClient    *SparkMasterClient
+	decommission         bool          //decommission the worker through the Spark master before deleting its pod
+	decommissionTimeout  time.Duration //wait for the executors of a decommissioned worker to finish
+	scaleInPolicy        string        //policy choosing the idle workers to remove, one of k8s_util.VictimPolicies
 }
 
 /**
@@ -43,51 +53,38 @@ Constructor for SparkWorkerDeployment struct
  */
 func NewSparkWorkerDeployment(deploymentClient *k8s_util.DeploymentClient,
 	imageName string,
+	pool string,
 	resource *k8s_util.DeploymentResource,
-	sparkWorkerOpts string) *SparkWorkerDeployment{
-	extraSparkWorker, _ :=strconv.Atoi(os.Getenv("EXTRA_SPARK_WORKER"))
+	sparkWorkerOpts string,
+	extraSparkWorker int,
+	sparkMasterClient *SparkMasterClient,
+	decommission bool,
+	decommissionTimeout time.Duration,
+	scaleInPolicy string) *SparkWorkerDeployment{
 	sparkWorker:=&SparkWorkerDeployment{
 		deploymentClient: deploymentClient,
 		imageName:        imageName,
 		labels:map[string]string{
 			"component": "spark-worker",
-			"pool": os.Getenv("SPARK_WORKER_POOL"),
+			"pool": pool,
 		},
 		nodeSelector:map[string]string{
-			"pool": os.Getenv("SPARK_WORKER_POOL"),
+			"pool": pool,
 		},
 		sparkWorkerOpts: sparkWorkerOpts,
 		sparkPath: "/usr/spark",
 		sparkService: "spark://spark-master:7077",
 		sparkMasterWebuiPort: "8080",
-		workerNameToNet:map[string]string{},
 		deploymentResource: resource,
 		extraSparkWorker: extraSparkWorker,
+		sparkMasterClient: sparkMasterClient,
+		decommission: decommission,
+		decommissionTimeout: decommissionTimeout,
+		scaleInPolicy: scaleInPolicy,
 	}
-	sparkWorker.prepareWorkerInfo()
 	return sparkWorker
 }
 
-/**
-This function is to generate a map between node network information and pod name for each worker.
- */
-func (sparkWorkerDeployment SparkWorkerDeployment)  prepareWorkerInfo (){
-	hasError := false
-	pods := sparkWorkerDeployment.getWorkers(&hasError)
-	if hasError {return}
-	for _,pod := range pods{
-		if pod.Status.Phase=="Running"{
-			// note: even though the pod is running, it still takes time for this worker to be shown
-			// in spark master
-			workerNet:=sparkWorkerDeployment.findWorkerNetWithPodName(pod.Name)
-			sparkWorkerDeployment.workerNameToNet[pod.Name]=workerNet
-		}
-		if pod.Status.Phase=="Pending"{
-			sparkWorkerDeployment.workerNameToNet[pod.Name]=""
-		}
-	}
-}
-
 /**
 This function is to generate configuration for a Spark worker pod
  */
@@ -113,7 +110,8 @@ func (sparkWorkerDeployment SparkWorkerDeployment) generateWorkerConfig() (*apiv
 					},
 					Args: []string{
 						//"echo $(hostname -i) "+sparkMasterDeployment.sparkMasterName+" >> /etc/hosts && python3 -m http.server",
-						sparkWorkerDeployment.sparkPath+"/bin/spark-class org.apache.spark.deploy.worker.Worker "+sparkWorkerDeployment.sparkService,
+						sparkWorkerDeployment.sparkPath+"/bin/spark-class org.apache.spark.deploy.worker.Worker"+
+							" --host $POD_IP --port "+strconv.Itoa(sparkWorkerPort)+" "+sparkWorkerDeployment.sparkService,
 					},
 					Ports: []apiv1.ContainerPort{
 						{
@@ -121,8 +119,19 @@ func (sparkWorkerDeployment SparkWorkerDeployment) generateWorkerConfig() (*apiv
 							Protocol:      apiv1.ProtocolTCP,
 							ContainerPort: 8081,
 						},
+						{
+							Name:          "worker-rpc",
+							Protocol:      apiv1.ProtocolTCP,
+							ContainerPort: sparkWorkerPort,
+						},
 					},
 					Env: []apiv1.EnvVar{
+						{
+							Name: "POD_IP",
+							ValueFrom: &apiv1.EnvVarSource{
+								FieldRef: &apiv1.ObjectFieldSelector{FieldPath: "status.podIP"},
+							},
+						},
 						{
 							Name: "SPARK_DAEMON_MEMORY",
 							Value: "1g",
@@ -145,7 +154,7 @@ func (sparkWorkerDeployment SparkWorkerDeployment) generateWorkerConfig() (*apiv
 
 			},
 			ImagePullSecrets: []apiv1.LocalObjectReference{
-				{"image-pull-secret-ibm-cloud"},
+				{Name: "image-pull-secret-ibm-cloud"},
 			},
 			NodeSelector: sparkWorkerDeployment.nodeSelector,
 		},
@@ -155,166 +164,261 @@ func (sparkWorkerDeployment SparkWorkerDeployment) generateWorkerConfig() (*apiv
 }
 
 /**
-This function retrieves Spark cluster from Spark master in json formation through http
+This function returns the pod names of up to count workers which can be removed: the pending workers first,
+then the DECOMMISSIONED workers and the ALIVE workers without any core in use, the ALIVE workers only once
+idleTracker has seen them idle long enough and in the order of the scale in policy. It returns an empty list
+if there is no such worker.
  */
-func (sparkWorkerDeployment SparkWorkerDeployment) getClusterInfo() ([]byte, error) {
-	sparkClusterInfoURL:=os.Getenv("SPARK_CLUSTER_INFO_URL")
-	response, err := http.Get(sparkClusterInfoURL)
-	if err!=nil{
-		log.Println("The cluster is down")
-		return nil,err
+func (sparkWorkerDeployment SparkWorkerDeployment) podsToRemove(ctx context.Context, count int,
+	idleTracker *workerIdleTracker) []string {
+	podNames:=[]string{}
+	hasError := false
+	workers:=sparkWorkerDeployment.getWorkers(&hasError)
+	if hasError {return podNames}
+	// the workers being released by a previous cycle are not picked again
+	pods:=[]apiv1.Pod{}
+	for _, pod := range workers{
+		if !sparkWorkerDeployment.isReleasing(pod,time.Now()){
+			pods=append(pods,pod)
+		}
 	}
-	responseData, err := ioutil.ReadAll(response.Body)
-	if err != nil {
+	for _, pod := range pods{
+		if pod.Status.Phase == "Pending" && len(podNames)<count{
+			podNames=append(podNames,pod.Name)
+		}
+	}
+	if len(podNames)==count {
+		return podNames
+	}
+	status,err:=sparkWorkerDeployment.sparkMasterClient.Status(ctx)
+	if err != nil{
 		log.Println(err)
-		return nil,err
+		return podNames
+	}
+	podNameByIP:=workerPodNameByIP(pods)
+	// a worker decommissioned in a previous cycle can't be used anymore, it goes before the ALIVE ones
+	for _, worker := range status.decommissionedIdleWorkers(){
+		if podName,exist:=podNameByIP[worker.Host]; exist && len(podNames)<count{
+			podNames=append(podNames,podName)
+		}
+	}
+	podsByName:=map[string]apiv1.Pod{}
+	for _, pod := range pods{
+		podsByName[pod.Name]=pod
 	}
-	return responseData,nil
+	zones:=sparkWorkerDeployment.workerZones(pods)
+	zoneSizes:=map[string]int{}
+	for _, podName := range podNameByIP{
+		zoneSizes[zones[podName]]++
+	}
+	candidates:=[]k8s_util.VictimCandidate{}
+	for _, worker := range status.idleWorkers(){
+		podName,exist:=podNameByIP[worker.Host]
+		if exist && idleTracker.idleLongEnough(worker.Host,time.Now()){
+			candidates=append(candidates,k8s_util.VictimCandidate{
+				Name:podName,
+				Created:podsByName[podName].CreationTimestamp.Time,
+				IdleSince:idleTracker.idleSinceOf(worker.Host),
+				Zone:zones[podName],
+			})
+		}
+	}
+	return append(podNames,k8s_util.SelectVictims(sparkWorkerDeployment.scaleInPolicy,candidates,zoneSizes,count-len(podNames))...)
 }
 
 /**
-This function returns the pod name of a idle worker randomly. If there is no idle workers in
-the cluster, it returns empty string.
+This function returns the zone of the node of every running worker pod by pod name, only for the zone-balanced
+scale in policy. A worker whose node can't be read has no zone.
  */
-func (sparkWorkerDeployment SparkWorkerDeployment) podToRemove() string {
-	hasError := false
-	pods:=sparkWorkerDeployment.getWorkers(&hasError)
-	if hasError {return ""}
+func (sparkWorkerDeployment SparkWorkerDeployment) workerZones(pods []apiv1.Pod) map[string]string {
+	zones:=map[string]string{}
+	if sparkWorkerDeployment.scaleInPolicy != k8s_util.VictimPolicyZoneBalanced {
+		return zones
+	}
+	nodeZones:=map[string]string{}
 	for _, pod := range pods{
-		if pod.Status.Phase == "Pending"{
-			return pod.Name
+		if pod.Status.Phase != "Running" || pod.Spec.NodeName == ""{
+			continue
 		}
-	}
-	clusterInfo,err:=sparkWorkerDeployment.getClusterInfo()
-	if err != nil{
-		log.Println(err)
-		return ""
-	}
-	for i:=0;i<len(strings.Split(jsoniter.Get(clusterInfo, "workers").ToString(),"},"));i++{
-		workerInfo:=jsoniter.Get(clusterInfo, "workers",i).ToString()
-		if jsoniter.Get([]byte(workerInfo), "coresused",).ToString() == "0" && jsoniter.Get([]byte(workerInfo), "state",).ToString()=="ALIVE" {
-			workerID := jsoniter.Get([]byte(workerInfo), "id", ).ToString()
-			podIP:=strings.Split(workerID,"-")[2]
-			podPort:=strings.Split(workerID,"-")[3]
-			podNet:=string(podIP+":"+podPort)
-			podName:=""
-			for name, net := range sparkWorkerDeployment.workerNameToNet {
-				if net==podNet{
-					podName=name
-					break
-				}
+		zone,exist:=nodeZones[pod.Spec.NodeName]
+		if !exist{
+			node,err:=sparkWorkerDeployment.deploymentClient.Clientset.CoreV1().Nodes().Get(pod.Spec.NodeName,metav1.GetOptions{})
+			if err != nil{
+				log.Printf("Can't get the node %s of worker %s: %v\n",pod.Spec.NodeName,pod.Name,err)
+			}else{
+				zone=k8s_util.NodeZone(node)
 			}
-			return podName
+			nodeZones[pod.Spec.NodeName]=zone
 		}
+		zones[pod.Name]=zone
 	}
-	return ""
+	return zones
 }
 
 /**
-This function returns the ALIVE workers number in the Spark cluster
+This function maps the IP of every running worker pod to its pod name. A worker binds to the IP of its pod,
+which is the host of the worker reported by the Spark master.
  */
-func (sparkWorkerDeployment SparkWorkerDeployment) getWorkerNumFromMaster() (int,error) {
-	clusterInfo,err:=sparkWorkerDeployment.getClusterInfo()
-	if err!=nil{
-		return -1,err
-	}
-	workerCount:=0
-	for i:=0;i<len(strings.Split(jsoniter.Get(clusterInfo, "workers").ToString(),"},"));i++{
-		workerInfo:=jsoniter.Get(clusterInfo, "workers",i).ToString()
-		if jsoniter.Get([]byte(workerInfo), "state",).ToString()=="ALIVE" {
-			workerCount+=1
+func workerPodNameByIP(pods []apiv1.Pod) map[string]string {
+	podNameByIP:=map[string]string{}
+	for _, pod := range pods{
+		if pod.Status.Phase == "Running" && pod.Status.PodIP != ""{
+			podNameByIP[pod.Status.PodIP]=pod.Name
 		}
 	}
-	return workerCount,nil
+	return podNameByIP
 }
 
-
 func (sparkWorkerDeployment SparkWorkerDeployment) getWorkers(hasError *bool)  [] apiv1.Pod {
 	workers,err:=sparkWorkerDeployment.deploymentClient.GetPodListWithLabels(sparkWorkerDeployment.labels)
 	if err != nil {*hasError=true}
-	for _, worker:=range workers{
-		if sparkWorkerDeployment.workerNameToNet[worker.Name]==NodePending{
-			if worker.Status.Phase=="Running"{
-				workerNet:=sparkWorkerDeployment.findWorkerNetWithPodName(worker.Name)
-				sparkWorkerDeployment.workerNameToNet[worker.Name]=workerNet
-			}
-		}
-
-	}
 	return workers
 }
 
 /**
-This function is to add a Spark worker to Spark cluster, then keep tracking the status of adding until
-the new worker joins the cluster
+This function is to add a Spark worker to Spark cluster, it returns once the worker pod is created.
+Several workers can be added concurrently.
  */
-func (sparkWorkerDeployment SparkWorkerDeployment) addWorker() error {
-	hasError := false
-	currWorkerNum:= len(sparkWorkerDeployment.getWorkers(&hasError))
-	if hasError {return errors.New("failed to get pods information")}
+func (sparkWorkerDeployment SparkWorkerDeployment) addWorker(ctx context.Context) error {
+	if ctx.Err() != nil {return ctx.Err()}
 	newWorkerName:=sparkWorkerDeployment.deploymentClient.AddPod(sparkWorkerDeployment.generateWorkerConfig())
-	for {
-		hasError = false
-		updatedWorkerNum:=len(sparkWorkerDeployment.getWorkers(&hasError))
-		if hasError {return errors.New("failed to get pods information")}
-		if updatedWorkerNum>currWorkerNum {
-			sparkWorkerDeployment.workerNameToNet[newWorkerName]=NodePending
-			break
+	if newWorkerName=="" {
+		return errors.New("failed to create a worker pod")
+	}
+	return nil
+}
+
+/**
+This function is to remove a worker in the Spark cluster based on the pod name of that worker,
+then keep tracking the status of that worker until its pod is gone or ctx is cancelled.
+The pod is marked with releasingAnnotation first so the next cycles don't pick it again, then releaseWorker makes
+sure no executor runs on the worker and the Spark master is read once more right before the pod is deleted. The
+pod is kept and unmarked if an executor landed on the worker meanwhile.
+Several workers can be removed concurrently.
+ */
+func (sparkWorkerDeployment SparkWorkerDeployment) removeWorker(ctx context.Context, podName string) error {
+	if podName!=""{
+		if ctx.Err() != nil {return ctx.Err()}
+		err:=sparkWorkerDeployment.deploymentClient.AnnotatePod(podName,releasingAnnotation,time.Now().Format(time.RFC3339))
+		if err!=nil {
+			return fmt.Errorf("worker %s is kept, it can't be marked as being released: %v",podName,err)
+		}
+		host,err:=sparkWorkerDeployment.releaseWorker(ctx,podName)
+		if err==nil && host!="" {
+			err=sparkWorkerDeployment.checkWorkerIdle(ctx,podName,host)
+		}
+		if err!=nil {
+			// the worker is kept, it can be picked again once idle
+			if unmarkErr:=sparkWorkerDeployment.deploymentClient.AnnotatePod(podName,releasingAnnotation,""); unmarkErr!=nil {
+				log.Printf("Worker %s stays marked as being released: %v\n",podName,unmarkErr)
+			}
+			return err
+		}
+		sparkWorkerDeployment.deploymentClient.DeletePod(podName)
+		for {
+			hasError := false
+			workers:=sparkWorkerDeployment.getWorkers(&hasError)
+			if hasError {return errors.New("failed to get pods information")}
+			if !containsPod(workers,podName) {
+				break
+			}
+			if !k8s_util.SleepWithContext(ctx,1000*time.Millisecond) {
+				log.Println("Stop waiting for worker "+podName+" to be removed")
+				return ctx.Err()
+			}
 		}
-		time.Sleep(1000*time.Millisecond)
 	}
 	return nil
 }
 
 /**
-This function is to get a worker's pod name based on the worker's net information (FORMAT: "NODE_IP:NODE_PORT")
+This function makes sure the worker of a pod doesn't run any executor before its pod is deleted, it returns the
+host of the worker, empty for a pod which is not running and has no worker yet.
+If decommission is enabled, the worker is decommissioned through the Spark master first, so no executor can be
+assigned to it anymore, and this function waits up to decommissionTimeout for its running executors to finish.
+Decommission needs Spark 3.1 or later. Without decommission, the caller checks the worker is still idle right
+before its pod is deleted, which narrows but doesn't close the window for a new executor.
+It returns an error if the pod must be kept.
  */
-func (sparkWorkerDeployment SparkWorkerDeployment) findWorkerNetWithPodName(podName string) string {
-	podsClient := sparkWorkerDeployment.deploymentClient.Clientset.CoreV1().Pods(sparkWorkerDeployment.deploymentClient.Namespace)
-	req:=podsClient.GetLogs(podName, &apiv1.PodLogOptions{})
-	readCloser, err := req.Stream()
-	if err != nil {
-		log.Println(err)
+func (sparkWorkerDeployment SparkWorkerDeployment) releaseWorker(ctx context.Context, podName string) (string, error) {
+	hasError := false
+	workers:=sparkWorkerDeployment.getWorkers(&hasError)
+	if hasError {return "",errors.New("failed to get pods information")}
+	host:=""
+	for _, pod := range workers {
+		if pod.Name==podName && pod.Status.Phase == "Running" {
+			host=pod.Status.PodIP
+		}
 	}
-	buf:=new(bytes.Buffer)
-	_,_ = buf.ReadFrom(readCloser)
-	workerNet:=""
+	if host=="" || !sparkWorkerDeployment.decommission {
+		return host,nil
+	}
+	status,err:=sparkWorkerDeployment.sparkMasterClient.Status(ctx)
 	if err != nil {
-		log.Println(err)
+		return "",fmt.Errorf("worker %s is kept, its executors can't be checked: %v",podName,err)
 	}
-	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
-	for scanner.Scan() {
-		if strings.Contains(scanner.Text(),"Starting Spark worker"){
-			workerNet=strings.Split(scanner.Text()," ")[7]
+	worker,exist:=status.workerOnHost(host)
+	if exist && worker.State=="ALIVE" {
+		if err:=sparkWorkerDeployment.sparkMasterClient.DecommissionWorker(ctx,host); err!=nil {
+			return "",fmt.Errorf("worker %s is kept: %v",podName,err)
 		}
+		log.Println("Worker "+podName+" on "+host+" is decommissioned")
 	}
-	return workerNet
+	deadline:=time.Now().Add(sparkWorkerDeployment.decommissionTimeout)
+	for exist && worker.CoresUsed>0 {
+		if time.Now().After(deadline) {
+			return "",fmt.Errorf("worker %s is kept, its executors using %d cores are still running after %v, "+
+				"it will be removed once idle",podName,worker.CoresUsed,sparkWorkerDeployment.decommissionTimeout)
+		}
+		if !k8s_util.SleepWithContext(ctx,1000*time.Millisecond) {
+			return "",ctx.Err()
+		}
+		status,err=sparkWorkerDeployment.sparkMasterClient.Status(ctx)
+		if err != nil {
+			return "",fmt.Errorf("worker %s is kept, its executors can't be checked: %v",podName,err)
+		}
+		worker,exist=status.workerOnHost(host)
+	}
+	return host,nil
 }
 
 /**
-This function is to remove a worker in the Spark cluster based on the pod name of that worker,
-then keep tracking the status of that worker until the worker is removed from Spark cluster
+This function reads the Spark master right before the pod of a worker is deleted, it returns an error if the
+worker on host got an executor since it was selected
  */
-func (sparkWorkerDeployment SparkWorkerDeployment) removeWorker(podName string) error {
-	if podName!=""{
-		hasError := false
-		workersNum:=len(sparkWorkerDeployment.getWorkers(&hasError))
-		if hasError {return errors.New("failed to get pods information")}
-			sparkWorkerDeployment.deploymentClient.DeletePod(podName)
-		for {
-			hasError = false
-			updatedWorkersNum:=len(sparkWorkerDeployment.getWorkers(&hasError))
-			if hasError {return errors.New("failed to get pods information")}
-			if workersNum>updatedWorkersNum {
-				delete(sparkWorkerDeployment.workerNameToNet,podName)
-				break
-			}
-			time.Sleep(1000*time.Millisecond)
-		}
+func (sparkWorkerDeployment SparkWorkerDeployment) checkWorkerIdle(ctx context.Context, podName string, host string) error {
+	status,err:=sparkWorkerDeployment.sparkMasterClient.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("worker %s is kept, its executors can't be checked: %v",podName,err)
+	}
+	if worker,exist:=status.workerOnHost(host); exist && worker.CoresUsed>0 {
+		return fmt.Errorf("worker %s is kept, it got executors using %d cores",podName,worker.CoresUsed)
 	}
 	return nil
 }
 
+/**
+This function returns true if the pod is marked as being released by a removeWorker which may still be running.
+A mark older than decommissionTimeout plus releaseMarkGracePeriod is left by an autoscaler which stopped, it is
+ignored.
+ */
+func (sparkWorkerDeployment SparkWorkerDeployment) isReleasing(pod apiv1.Pod, timeNow time.Time) bool {
+	markedAt,err:=time.Parse(time.RFC3339,pod.Annotations[releasingAnnotation])
+	if err != nil {
+		return false
+	}
+	return timeNow.Before(markedAt.Add(sparkWorkerDeployment.decommissionTimeout+releaseMarkGracePeriod))
+}
+
+func containsPod(pods []apiv1.Pod, podName string) bool {
+	for _, pod := range pods {
+		if pod.Name==podName {
+			return true
+		}
+	}
+	return false
+}
+
 /**
 This function is to delete all workers in Spark cluster
  */
@@ -0,0 +1,202 @@
+package spark_deployment
+
+import (
+	"context"
+	"fmt"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPodsToRemoveMapsWorkersByPodIP(t *testing.T) {
+	pods := []apiv1.Pod{
+		newFakeWorkerPod("spark-worker-1", apiv1.PodPending),
+		newFakeRunningWorkerPod("spark-worker-2", "172.30.0.2"),
+		newFakeRunningWorkerPod("spark-worker-3", "172.30.0.3"),
+		newFakeRunningWorkerPod("spark-worker-4", "fd00::4"),
+		newFakeRunningWorkerPod("spark-worker-5", "172.30.0.5"),
+	}
+	cluster, _, master := newFakeSparkCluster(pods, `{
+		"workers": [
+			{"id": "worker-20190620000000-172.30.0.2-7078", "host": "172.30.0.2", "port": 7078, "coresused": 1, "state": "ALIVE"},
+			{"id": "worker-20190620000000-172.30.0.3-7078", "host": "172.30.0.3", "port": 7078, "coresused": 0, "state": "ALIVE"},
+			{"id": "worker-20190620000000-fd00::4-7078", "host": "fd00::4", "port": 7078, "coresused": 0, "state": "ALIVE"},
+			{"id": "worker-20190620000000-172.30.0.9-7078", "host": "172.30.0.9", "port": 7078, "coresused": 0, "state": "ALIVE"},
+			{"id": "worker-20190620000000-172.30.0.5-7078", "host": "172.30.0.5", "port": 7078, "coresused": 0, "state": "DECOMMISSIONED"}
+		],
+		"coresused": 1
+	}`)
+	defer master.Close()
+	// the pending worker first, then the decommissioned and the idle workers of known pods, the busy and unknown
+	// workers are kept
+	podNames := cluster.sparkWorkerDeployment.podsToRemove(context.Background(), 5, newWorkerIdleTracker(0))
+	sort.Strings(podNames[2:])
+	assert.DeepEqual(t, podNames, []string{"spark-worker-1", "spark-worker-5", "spark-worker-3", "spark-worker-4"})
+	assert.DeepEqual(t, cluster.sparkWorkerDeployment.podsToRemove(context.Background(), 2, newWorkerIdleTracker(0)),
+		[]string{"spark-worker-1", "spark-worker-5"})
+}
+
+func TestPodsToRemoveWithScaleInPolicy(t *testing.T) {
+	created := time.Date(2019, time.June, 20, 12, 0, 0, 0, time.UTC)
+	pods := []apiv1.Pod{}
+	for i, nodeName := range []string{"node-1", "node-1", "node-2"} {
+		pod := newFakeRunningWorkerPod(fmt.Sprintf("spark-worker-%d", i+1), fmt.Sprintf("172.30.0.%d", i+1))
+		pod.CreationTimestamp = metav1.NewTime(created.Add(time.Duration(i) * time.Hour))
+		pod.Spec.NodeName = nodeName
+		pods = append(pods, pod)
+	}
+	cluster, clientSet, master := newFakeSparkCluster(pods, `{
+		"workers": [
+			{"host": "172.30.0.1", "port": 7078, "coresused": 0, "state": "ALIVE"},
+			{"host": "172.30.0.2", "port": 7078, "coresused": 0, "state": "ALIVE"},
+			{"host": "172.30.0.3", "port": 7078, "coresused": 0, "state": "ALIVE"}
+		]
+	}`)
+	defer master.Close()
+	for nodeName, zone := range map[string]string{"node-1": "tor01", "node-2": "tor02"} {
+		_, err := clientSet.CoreV1().Nodes().Create(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name: nodeName, Labels: map[string]string{apiv1.LabelZoneFailureDomain: zone}}})
+		assert.NilError(t, err)
+	}
+	workerDeployment := cluster.sparkWorkerDeployment
+	idleTracker := newWorkerIdleTracker(0)
+	status, err := workerDeployment.sparkMasterClient.Status(context.Background())
+	assert.NilError(t, err)
+	idleTracker.update(created, SparkMasterStatus{Workers: status.Workers[1:2]})
+	idleTracker.update(created.Add(time.Minute), status)
+
+	workerDeployment.scaleInPolicy = "newest"
+	assert.DeepEqual(t, workerDeployment.podsToRemove(context.Background(), 2, idleTracker),
+		[]string{"spark-worker-3", "spark-worker-2"})
+	workerDeployment.scaleInPolicy = "oldest"
+	assert.DeepEqual(t, workerDeployment.podsToRemove(context.Background(), 1, idleTracker), []string{"spark-worker-1"})
+	// spark-worker-2 has been idle the longest
+	workerDeployment.scaleInPolicy = "least-recently-used"
+	assert.DeepEqual(t, workerDeployment.podsToRemove(context.Background(), 1, idleTracker), []string{"spark-worker-2"})
+	// node-1 in tor01 runs 2 workers
+	workerDeployment.scaleInPolicy = "zone-balanced"
+	podNames := workerDeployment.podsToRemove(context.Background(), 1, idleTracker)
+	assert.Assert(t, podNames[0] == "spark-worker-1" || podNames[0] == "spark-worker-2")
+}
+
+func TestWorkerBindsToPodIP(t *testing.T) {
+	cluster, _, master := newFakeSparkCluster(nil, `{}`)
+	defer master.Close()
+	container := cluster.sparkWorkerDeployment.generateWorkerConfig().Spec.Containers[0]
+	assert.Assert(t, strings.Contains(container.Args[0], "--host $POD_IP --port 7078 spark://spark-master:7077"))
+	assert.Equal(t, container.Env[0].Name, "POD_IP")
+	assert.Equal(t, container.Env[0].ValueFrom.FieldRef.FieldPath, "status.podIP")
+}
+
+/**
+This function starts a fake Spark master with one ALIVE worker on 172.30.0.2 running an executor of 1 core,
+decommissioning the worker through the master finishes the executor
+ */
+func newFakeDecommissioningMaster() *httptest.Server {
+	var lock sync.Mutex
+	coresUsed, state := 1, "ALIVE"
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if request.URL.Path == "/workers/kill/" {
+			coresUsed, state = 0, "DECOMMISSIONED"
+			return
+		}
+		_, _ = fmt.Fprintf(writer, `{"workers": [{"host": "172.30.0.2", "port": 7078, "coresused": %d, "state": %q}]}`,
+			coresUsed, state)
+	}))
+}
+
+func TestRemoveWorkerChecksTheWorkerIsIdle(t *testing.T) {
+	master := newFakeDecommissioningMaster()
+	defer master.Close()
+	cluster, clientSet, fakeMaster := newFakeSparkCluster([]apiv1.Pod{newFakeRunningWorkerPod("spark-worker-1", "172.30.0.2")}, `{}`)
+	defer fakeMaster.Close()
+	workerDeployment := cluster.sparkWorkerDeployment
+	workerDeployment.sparkMasterClient = NewSparkMasterClient(master.URL+"/json", time.Second, 0)
+
+	// an executor landed on the worker since it was selected, the pod is kept
+	err := workerDeployment.removeWorker(context.Background(), "spark-worker-1")
+	assert.ErrorContains(t, err, "it got executors using 1 cores")
+	assert.DeepEqual(t, workerPodNames(clientSet), []string{"spark-worker-1"})
+
+	// the worker is decommissioned first, which finishes its executor in the fake master, then deleted
+	workerDeployment.decommission = true
+	assert.NilError(t, workerDeployment.removeWorker(context.Background(), "spark-worker-1"))
+	assert.DeepEqual(t, workerPodNames(clientSet), []string{})
+}
+
+func TestRemoveWorkerKeepsBusyDecommissionedWorker(t *testing.T) {
+	cluster, clientSet, master := newFakeSparkCluster([]apiv1.Pod{newFakeRunningWorkerPod("spark-worker-1", "172.30.0.2")},
+		`{"workers": [{"host": "172.30.0.2", "port": 7078, "coresused": 2, "state": "DECOMMISSIONED"}]}`)
+	defer master.Close()
+	workerDeployment := cluster.sparkWorkerDeployment
+	workerDeployment.decommission = true
+	workerDeployment.decommissionTimeout = 0
+	err := workerDeployment.removeWorker(context.Background(), "spark-worker-1")
+	assert.ErrorContains(t, err, "still running after 0s")
+	assert.DeepEqual(t, workerPodNames(clientSet), []string{"spark-worker-1"})
+	// the busy decommissioned worker isn't a candidate to remove either
+	assert.DeepEqual(t, workerDeployment.podsToRemove(context.Background(), 1, newWorkerIdleTracker(0)), []string{})
+}
+
+func TestRemoveWorkerMarksThePodWhileReleasing(t *testing.T) {
+	cluster, clientSet, fakeMaster := newFakeSparkCluster([]apiv1.Pod{newFakeRunningWorkerPod("spark-worker-1", "172.30.0.2")}, `{}`)
+	defer fakeMaster.Close()
+	var lock sync.Mutex
+	marks := []string{}
+	master := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		pod, err := clientSet.CoreV1().Pods("spark").Get("spark-worker-1", metav1.GetOptions{})
+		lock.Lock()
+		if err == nil {
+			marks = append(marks, pod.Annotations[releasingAnnotation])
+		}
+		lock.Unlock()
+		_, _ = fmt.Fprint(writer, `{"workers": [{"host": "172.30.0.2", "port": 7078, "coresused": 1, "state": "ALIVE"}]}`)
+	}))
+	defer master.Close()
+	workerDeployment := cluster.sparkWorkerDeployment
+	workerDeployment.sparkMasterClient = NewSparkMasterClient(master.URL, time.Second, 0)
+
+	// the pod is marked when the master is read right before the delete, and unmarked once kept
+	err := workerDeployment.removeWorker(context.Background(), "spark-worker-1")
+	assert.ErrorContains(t, err, "it got executors using 1 cores")
+	lock.Lock()
+	assert.Equal(t, len(marks), 1)
+	_, parseErr := time.Parse(time.RFC3339, marks[0])
+	lock.Unlock()
+	assert.NilError(t, parseErr)
+	pod, err := clientSet.CoreV1().Pods("spark").Get("spark-worker-1", metav1.GetOptions{})
+	assert.NilError(t, err)
+	_, marked := pod.Annotations[releasingAnnotation]
+	assert.Assert(t, !marked)
+}
+
+func TestPodsToRemoveSkipsWorkersBeingReleased(t *testing.T) {
+	pods := []apiv1.Pod{
+		newFakeRunningWorkerPod("spark-worker-1", "172.30.0.1"),
+		newFakeRunningWorkerPod("spark-worker-2", "172.30.0.2"),
+		newFakeWorkerPod("spark-worker-3", apiv1.PodPending),
+	}
+	pods[0].Annotations = map[string]string{releasingAnnotation: time.Now().Format(time.RFC3339)}
+	// a mark left by an autoscaler which stopped while releasing the worker
+	pods[1].Annotations = map[string]string{
+		releasingAnnotation: time.Now().Add(-time.Minute - releaseMarkGracePeriod - time.Second).Format(time.RFC3339)}
+	pods[2].Annotations = map[string]string{releasingAnnotation: time.Now().Format(time.RFC3339)}
+	cluster, _, master := newFakeSparkCluster(pods, `{
+		"workers": [
+			{"host": "172.30.0.1", "port": 7078, "coresused": 0, "state": "ALIVE"},
+			{"host": "172.30.0.2", "port": 7078, "coresused": 0, "state": "ALIVE"}
+		]
+	}`)
+	defer master.Close()
+	assert.DeepEqual(t, cluster.sparkWorkerDeployment.podsToRemove(context.Background(), 3, newWorkerIdleTracker(0)),
+		[]string{"spark-worker-2"})
+}
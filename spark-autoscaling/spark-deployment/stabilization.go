@@ -0,0 +1,163 @@
+package spark_deployment
+
+import (
+	"time"
+)
+
+/**
+Scaling behavior of the Spark workers, similar to the behavior policies of the HorizontalPodAutoscaler,
+every value is a duration like "5m", "0s" disables it
+ */
+type ScalingBehaviorConfig struct {
+	WorkerIdleTime               string `json:"workerIdleTime" env:"WORKER_IDLE_TIME"`                              //an ALIVE worker is removed once idle for this long
+	ScaleUpStabilizationWindow   string `json:"scaleUpStabilizationWindow" env:"SCALE_UP_STABILIZATION_WINDOW"`     //scale out to the lowest worker number desired in this window
+	ScaleDownStabilizationWindow string `json:"scaleDownStabilizationWindow" env:"SCALE_DOWN_STABILIZATION_WINDOW"` //scale in to the highest worker number desired in this window
+	ScaleOutCooldown             string `json:"scaleOutCooldown" env:"SCALE_OUT_COOLDOWN"`                          //wait after a scale out before the next scale out
+	ScaleInCooldown              string `json:"scaleInCooldown" env:"SCALE_IN_COOLDOWN"`                            //wait after any scale out or scale in before the next scale in
+}
+
+/**
+A number of workers desired by the autoscaler at some time
+ */
+type workerRecommendation struct {
+	time    time.Time
+	workers int
+}
+
+/**
+Stabilization windows and cooldowns of the Spark worker autoscaler, it keeps the worker numbers desired during
+the longest window and the time of the last scale out and scale in
+ */
+type scaleStabilizer struct {
+	scaleUpWindow    time.Duration
+	scaleDownWindow  time.Duration
+	scaleOutCooldown time.Duration
+	scaleInCooldown  time.Duration
+	recommendations  []workerRecommendation
+	lastScaleOut     time.Time
+	lastScaleIn      time.Time
+}
+
+/**
+This function parses the durations of a validated configuration, an invalid duration disables its setting
+ */
+func newScaleStabilizer(behavior ScalingBehaviorConfig) *scaleStabilizer {
+	return &scaleStabilizer{
+		scaleUpWindow:    parseBehaviorDuration(behavior.ScaleUpStabilizationWindow),
+		scaleDownWindow:  parseBehaviorDuration(behavior.ScaleDownStabilizationWindow),
+		scaleOutCooldown: parseBehaviorDuration(behavior.ScaleOutCooldown),
+		scaleInCooldown:  parseBehaviorDuration(behavior.ScaleInCooldown),
+	}
+}
+
+func parseBehaviorDuration(duration string) time.Duration {
+	parsed, err := time.ParseDuration(duration)
+	if err != nil || parsed < 0 {
+		return 0
+	}
+	return parsed
+}
+
+/**
+This function records desiredWorkerNum at timeNow and returns the number of workers to scale to: the worker number
+only goes up to the lowest number desired during the scale up window, and only goes down to the highest number
+desired during the scale down window, so a short burst or a short idle moment doesn't scale the workers
+ */
+func (stabilizer *scaleStabilizer) stabilize(timeNow time.Time, desiredWorkerNum int, currWorkerNum int) int {
+	stabilizer.recommendations = append(stabilizer.recommendations, workerRecommendation{time: timeNow, workers: desiredWorkerNum})
+	longestWindow := stabilizer.scaleUpWindow
+	if stabilizer.scaleDownWindow > longestWindow {
+		longestWindow = stabilizer.scaleDownWindow
+	}
+	upRecommendation, downRecommendation := desiredWorkerNum, desiredWorkerNum
+	recommendations := []workerRecommendation{}
+	for _, recommendation := range stabilizer.recommendations {
+		age := timeNow.Sub(recommendation.time)
+		if age > longestWindow {
+			continue
+		}
+		recommendations = append(recommendations, recommendation)
+		if age <= stabilizer.scaleUpWindow && recommendation.workers < upRecommendation {
+			upRecommendation = recommendation.workers
+		}
+		if age <= stabilizer.scaleDownWindow && recommendation.workers > downRecommendation {
+			downRecommendation = recommendation.workers
+		}
+	}
+	stabilizer.recommendations = recommendations
+	stabilized := currWorkerNum
+	if stabilized < upRecommendation {
+		stabilized = upRecommendation
+	}
+	if stabilized > downRecommendation {
+		stabilized = downRecommendation
+	}
+	return stabilized
+}
+
+/**
+This function returns true if a scale out (delta > 0) or a scale in (delta < 0) has to wait for a cooldown at timeNow
+ */
+func (stabilizer *scaleStabilizer) inCooldown(timeNow time.Time, delta int) bool {
+	if delta > 0 {
+		return timeNow.Sub(stabilizer.lastScaleOut) < stabilizer.scaleOutCooldown
+	}
+	if delta < 0 {
+		return timeNow.Sub(stabilizer.lastScaleOut) < stabilizer.scaleInCooldown ||
+			timeNow.Sub(stabilizer.lastScaleIn) < stabilizer.scaleInCooldown
+	}
+	return false
+}
+
+func (stabilizer *scaleStabilizer) recordScaleOut(timeNow time.Time) {
+	stabilizer.lastScaleOut = timeNow
+}
+
+func (stabilizer *scaleStabilizer) recordScaleIn(timeNow time.Time) {
+	stabilizer.lastScaleIn = timeNow
+}
+
+/**
+This struct keeps since when every ALIVE worker reported by the Spark master has been idle, by worker host
+ */
+type workerIdleTracker struct {
+	idleTime  time.Duration
+	idleSince map[string]time.Time
+}
+
+func newWorkerIdleTracker(idleTime time.Duration) *workerIdleTracker {
+	return &workerIdleTracker{idleTime: idleTime, idleSince: map[string]time.Time{}}
+}
+
+/**
+This function records the idle workers of status at timeNow, a worker which got executors or left is forgotten
+ */
+func (tracker *workerIdleTracker) update(timeNow time.Time, status SparkMasterStatus) {
+	idleSince := map[string]time.Time{}
+	for _, worker := range status.idleWorkers() {
+		if since, exist := tracker.idleSince[worker.Host]; exist {
+			idleSince[worker.Host] = since
+		} else {
+			idleSince[worker.Host] = timeNow
+		}
+	}
+	tracker.idleSince = idleSince
+}
+
+/**
+This function returns since when the worker on host has been idle, zero if it isn't idle
+ */
+func (tracker *workerIdleTracker) idleSinceOf(host string) time.Time {
+	return tracker.idleSince[host]
+}
+
+/**
+This function returns true if the worker on host has been idle for idleTime at timeNow, always true without idleTime
+ */
+func (tracker *workerIdleTracker) idleLongEnough(host string, timeNow time.Time) bool {
+	if tracker.idleTime <= 0 {
+		return true
+	}
+	since, exist := tracker.idleSince[host]
+	return exist && timeNow.Sub(since) >= tracker.idleTime
+}
@@ -0,0 +1,75 @@
+package spark_deployment
+
+import (
+	"context"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+func TestScaleStabilizer(t *testing.T) {
+	stabilizer := newScaleStabilizer(ScalingBehaviorConfig{ScaleUpStabilizationWindow: "1m", ScaleDownStabilizationWindow: "5m"})
+	start := time.Date(2019, 6, 20, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, stabilizer.stabilize(start, 4, 4), 4)
+	// a short idle moment doesn't remove the workers until it lasts for the scale down window
+	assert.Equal(t, stabilizer.stabilize(start.Add(time.Minute), 2, 4), 4)
+	assert.Equal(t, stabilizer.stabilize(start.Add(5*time.Minute), 2, 4), 4)
+	assert.Equal(t, stabilizer.stabilize(start.Add(5*time.Minute+time.Second), 2, 4), 2)
+	assert.Equal(t, stabilizer.stabilize(start.Add(5*time.Minute+30*time.Second), 2, 2), 2)
+	// a burst is followed once it lasts for the scale up window, to its lowest value
+	assert.Equal(t, stabilizer.stabilize(start.Add(6*time.Minute), 8, 2), 2)
+	assert.Equal(t, stabilizer.stabilize(start.Add(6*time.Minute+30*time.Second), 6, 2), 2)
+	assert.Equal(t, stabilizer.stabilize(start.Add(7*time.Minute+time.Second), 7, 2), 6)
+
+	// without windows the desired number is followed right away
+	stabilizer = newScaleStabilizer(ScalingBehaviorConfig{})
+	assert.Equal(t, stabilizer.stabilize(start, 4, 2), 4)
+	assert.Equal(t, stabilizer.stabilize(start.Add(time.Second), 1, 4), 1)
+	// the recommendations older than the longest window are forgotten
+	assert.Equal(t, len(stabilizer.recommendations), 1)
+}
+
+func TestScaleStabilizerCooldown(t *testing.T) {
+	stabilizer := newScaleStabilizer(ScalingBehaviorConfig{ScaleOutCooldown: "30s", ScaleInCooldown: "5m"})
+	start := time.Date(2019, 6, 20, 8, 0, 0, 0, time.UTC)
+	assert.Assert(t, !stabilizer.inCooldown(start, 1))
+	assert.Assert(t, !stabilizer.inCooldown(start, -1))
+	stabilizer.recordScaleOut(start)
+	assert.Assert(t, stabilizer.inCooldown(start.Add(10*time.Second), 1))
+	assert.Assert(t, !stabilizer.inCooldown(start.Add(30*time.Second), 1))
+	// no scale in shortly after a scale out
+	assert.Assert(t, stabilizer.inCooldown(start.Add(time.Minute), -1))
+	assert.Assert(t, !stabilizer.inCooldown(start.Add(5*time.Minute), -1))
+	stabilizer.recordScaleIn(start.Add(5 * time.Minute))
+	assert.Assert(t, stabilizer.inCooldown(start.Add(6*time.Minute), -1))
+	assert.Assert(t, !stabilizer.inCooldown(start.Add(6*time.Minute), 1))
+	assert.Assert(t, !stabilizer.inCooldown(start.Add(6*time.Minute), 0))
+}
+
+func TestPodsToRemoveWaitsForTheWorkerIdleTime(t *testing.T) {
+	pods := []apiv1.Pod{
+		newFakeRunningWorkerPod("spark-worker-1", "172.30.0.1"),
+		newFakeRunningWorkerPod("spark-worker-2", "172.30.0.2"),
+	}
+	cluster, _, master := newFakeSparkCluster(pods, `{"workers": [
+		{"host": "172.30.0.1", "port": 7078, "coresused": 0, "state": "ALIVE"},
+		{"host": "172.30.0.2", "port": 7078, "coresused": 0, "state": "ALIVE"}
+	]}`)
+	defer master.Close()
+	tracker := newWorkerIdleTracker(time.Minute)
+	now := time.Now()
+	tracker.update(now.Add(-2*time.Minute), SparkMasterStatus{Workers: []SparkWorkerInfo{
+		{Host: "172.30.0.1", State: "ALIVE"},
+		{Host: "172.30.0.2", State: "ALIVE", CoresUsed: 1},
+	}})
+	tracker.update(now, SparkMasterStatus{Workers: []SparkWorkerInfo{
+		{Host: "172.30.0.1", State: "ALIVE"},
+		{Host: "172.30.0.2", State: "ALIVE"},
+	}})
+	// the second worker just finished its executor
+	assert.DeepEqual(t, cluster.sparkWorkerDeployment.podsToRemove(context.Background(), 2, tracker),
+		[]string{"spark-worker-1"})
+	assert.Assert(t, !tracker.idleLongEnough("172.30.0.2", now.Add(59*time.Second)))
+	assert.Assert(t, tracker.idleLongEnough("172.30.0.2", now.Add(time.Minute)))
+}
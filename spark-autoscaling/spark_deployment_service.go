@@ -1,26 +1,37 @@
 package main
 
 import (
+	"context"
+	k8sutil "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/k8s-util"
 	. "github.ibm.com/AdvancedAnalyticsCanada/custom-autoscaling/spark-autoscaling/spark-deployment"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"log"
 	"os"
-	"strconv"
 )
 
 func main() {
-	// isInCluster is used to determine if this app is running in the developer's local side
-	// or in the k8s cluster.
-	isInCluster,err:=strconv.ParseBool(os.Getenv("IS_IN_CLUSTER"))
+	// the configuration is read from the file in CONFIG_FILE, environment variables override its values,
+	// without CONFIG_FILE everything is read from the environment variables
+	config,err:=LoadSparkClusterConfig(os.Getenv("CONFIG_FILE"))
 	if err!=nil{
-		panic("Missing environment variable 'IS_IN_CLUSTER'")
+		log.Fatal(err)
 	}
-	cluster:=NewSparkCluster(isInCluster)
+	cluster:=NewSparkCluster(config)
 
+	// expose the autoscaler metrics to Prometheus, ":9090" by default
+	k8sutil.ServeMetrics(config.MetricsAddress)
+	// stop auto scaling when kubernetes stops the pod
+	ctx := k8sutil.SetupSignalContext()
+	// if leader election is enabled, only the elected leader among the replicas deploys and scales the Spark cluster.
 	// if cleanExistingDeployment is true, everything related to the Spark cluster will be redeployed,
 	// including Spark master, master UI service, master service and all workers
-	cleanExistingDeployment,err:=strconv.ParseBool(os.Getenv("CLEAN_EXISTING_DEPLOYMENT"))
-	if err!=nil{
-		panic("Missing environment variable 'CLEAN_EXISTING_DEPLOYMENT'")
+	if config.LeaderElection.Enabled {
+		deploymentClient := cluster.DeploymentClient()
+		k8sutil.RunWithLeaderElection(ctx,deploymentClient.Clientset,config.LeaderElection.Namespace,config.LeaderElection.LockName,
+			func(ctx context.Context) {
+				cluster.Deploy(ctx,config.CleanExistingDeployment)
+			})
+	} else {
+		cluster.Deploy(ctx,config.CleanExistingDeployment)
 	}
-	cluster.Deploy(cleanExistingDeployment)
-}
\ No newline at end of file
+}